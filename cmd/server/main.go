@@ -0,0 +1,141 @@
+// Servidor dedicado sin ventana: no enlaza Ebiten, así que se puede compilar
+// con CGO_ENABLED=0 y ejecutar en un contenedor.
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/demonodojo/rabbits/game/match"
+	"github.com/demonodojo/rabbits/game/network"
+	"github.com/demonodojo/rabbits/game/replay"
+)
+
+func main() {
+	addr := flag.String("addr", ":8080", "Address the WebSocket server listens on")
+	path := flag.String("path", network.DefaultPath, "Path of the WebSocket endpoint")
+	name := flag.String("name", "", "Name announced to LAN discovery; the host name if empty")
+	discovery := flag.String("discovery", "", "UDP address answering LAN discovery probes, e.g. "+network.DefaultDiscoveryAddr+"; off if empty. Only enable it on a private network")
+	pingInterval := flag.Duration("ping-interval", network.DefaultPingInterval, "How often clients are pinged")
+	pongTimeout := flag.Duration("pong-timeout", network.DefaultPongTimeout, "How long a silent client is kept before it is dropped")
+	grace := flag.Duration("grace", match.DefaultGracePeriod, "How long a disconnected player's rabbit is kept for them to resume")
+	maxRewind := flag.Duration("max-rewind", match.DefaultMaxRewind, "How far back shots are checked to compensate the shooter's latency; 0 disables lag compensation")
+	flushInterval := flag.Duration("flush-interval", 0, "Minimum time between two writes to a client; pending messages are batched")
+	maxPending := flag.Int("max-pending", network.DefaultMaxPending, "Messages queued for a client before it is considered too slow")
+	dropSlow := flag.Bool("drop-slow", false, "Drop messages for slow clients instead of disconnecting them")
+	overflow := flag.String("overflow", network.DropOldest.String(), "Which messages are dropped for slow clients with -drop-slow: drop-oldest, drop-newest or coalesce")
+	incomingCapacity := flag.Int("incoming-capacity", network.DefaultIncomingCapacity, "Messages received from clients kept until the match reads them")
+	incomingOverflow := flag.String("incoming-overflow", network.Block.String(), "What to do when the incoming queue is full: block, drop-oldest, drop-newest or coalesce")
+	readLimit := flag.Int64("read-limit", network.DefaultReadLimit, "Largest websocket frame accepted from a client, in bytes")
+	limits := match.DefaultLimits()
+	flag.IntVar(&limits.MaxMessageSize, "max-message-size", limits.MaxMessageSize, "Largest message accepted from a client, in bytes")
+	flag.Float64Var(&limits.MessageRate, "message-rate", limits.MessageRate, "Messages per second a client may send")
+	flag.Float64Var(&limits.InputRate, "input-rate", limits.InputRate, "Inputs per second a client may send")
+	flag.Float64Var(&limits.FireRate, "fire-rate", limits.FireRate, "Inputs with fire pressed per second a client may send")
+	flag.Float64Var(&limits.CatchUpRate, "catch-up-rate", limits.CatchUpRate, "Extra inputs per second applied to a rabbit that fell behind; 0 applies one per tick")
+	flag.Float64Var(&limits.ChatRate, "chat-rate", limits.ChatRate, "Chat messages per second a client may send")
+	chatFilter := match.DefaultChatFilter()
+	flag.IntVar(&chatFilter.MaxLength, "max-chat-length", chatFilter.MaxLength, "Longest chat message, in characters; longer ones are cut")
+	flag.IntVar(&limits.MaxViolations, "max-violations", limits.MaxViolations, "Invalid messages tolerated in a row before a client is kicked; 0 never kicks")
+	maxRooms := flag.Int("max-rooms", match.DefaultMaxRooms, "Rooms that may be open at once")
+	maxPlayers := flag.Int("max-players", match.DefaultMaxPlayers, "Players per room unless the room asks for fewer")
+	maxSpectators := flag.Int("max-spectators", match.DefaultMaxSpectators, "Spectators per room; 0 disables spectating")
+	emptyTimeout := flag.Duration("empty-timeout", match.DefaultEmptyTimeout, "How long an empty room created by players is kept")
+	record := flag.String("record", "", "Record the default room to this replay file")
+	stats := flag.Duration("stats", 0, "How often queue metrics are logged; 0 disables them")
+	snapshotRate := flag.Int("snapshot-rate", match.DefaultSnapshotRate, "World snapshots sent to each client per second")
+	flag.Parse()
+
+	outboundPolicy, err := network.ParseOverflowPolicy(*overflow)
+	if err != nil {
+		log.Fatal(err)
+	}
+	incomingPolicy, err := network.ParseOverflowPolicy(*incomingOverflow)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	server := network.NewServer(*addr)
+	server.Path = *path
+	server.Name = *name
+	server.Discovery = *discovery
+	server.Heartbeat = network.Heartbeat{Interval: *pingInterval, Timeout: *pongTimeout}
+	server.Outbound = network.Outbound{FlushInterval: *flushInterval, MaxPending: *maxPending, Overflow: outboundPolicy}
+	if *dropSlow {
+		server.Outbound.Slow = network.SlowDrop
+	}
+	server.ReadLimit = *readLimit
+	server.Incoming = network.QueueOptions{Capacity: *incomingCapacity, Overflow: incomingPolicy}
+	if err := server.Start(); err != nil {
+		log.Fatal(err)
+	}
+	if *stats > 0 {
+		go logStats(ctx, server, *stats)
+	}
+
+	lobby := match.NewLobby(server)
+	lobby.SnapshotRate = *snapshotRate
+	lobby.GracePeriod = *grace
+	lobby.MaxRewind = *maxRewind
+	lobby.Limits = limits
+	lobby.ChatFilter = chatFilter
+	lobby.MaxRooms = *maxRooms
+	lobby.DefaultMaxPlayers = *maxPlayers
+	lobby.Room(match.DefaultRoom).MaxPlayers = *maxPlayers
+	lobby.DefaultMaxSpectators = *maxSpectators
+	lobby.Room(match.DefaultRoom).MaxSpectators = *maxSpectators
+	lobby.EmptyTimeout = *emptyTimeout
+	if *record != "" {
+		recorder, err := replay.Create(*record)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer func() {
+			if err := recorder.Close(); err != nil {
+				log.Println("record:", err)
+			}
+		}()
+		lobby.Room(match.DefaultRoom).Match.Recorder = recorder
+	}
+	log.Printf("Servidor dedicado en %s", *addr)
+	if err := lobby.Run(ctx); err != nil && err != context.Canceled {
+		log.Fatal(err)
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Println("shutdown:", err)
+	}
+	log.Println("Servidor detenido")
+}
+
+// logStats escribe cada interval las métricas de las colas del servidor.
+func logStats(ctx context.Context, server *network.Server, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		in := server.IncomingStats()
+		log.Printf("entrada: %d/%d pendientes, máximo %d, %d descartados",
+			in.Len, in.Capacity, in.HighWater, in.Dropped)
+		for _, conn := range server.ClientManager().GetClients() {
+			if out, ok := server.OutgoingStats(conn); ok {
+				log.Printf("salida %s: %d/%d pendientes, máximo %d, %d descartados, %d agrupados",
+					conn.RemoteAddr(), out.Len, out.Capacity, out.HighWater, out.Dropped, out.Coalesced)
+			}
+		}
+	}
+}
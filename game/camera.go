@@ -77,11 +77,7 @@ func (c *Camera) Reset() {
 }
 
 func (c *Camera) Update(r *Rabbit) error {
-	if c.attached {
-		c.Position[0] = r.Position.X + r.halfW - c.viewportCenter()[0]
-		c.Position[1] = r.Position.Y + r.halfH - c.viewportCenter()[1]
-		c.viewportCenter()
-	}
+	c.follow(r)
 
 	if ebiten.IsKeyPressed(ebiten.KeyA) {
 		c.Position[0] -= 1
@@ -138,6 +134,20 @@ func (c *Camera) Update(r *Rabbit) error {
 	return nil
 }
 
+// Follow sigue a r si la cámara está enganchada, sin leer el teclado ni el
+// ratón, para cuando se están usando para otra cosa.
+func (c *Camera) Follow(r *Rabbit) {
+	c.follow(r)
+	c.Matrix = c.worldMatrix()
+}
+
+func (c *Camera) follow(r *Rabbit) {
+	if c.attached {
+		c.Position[0] = r.Position.X + r.halfW - c.viewportCenter()[0]
+		c.Position[1] = r.Position.Y + r.halfH - c.viewportCenter()[1]
+	}
+}
+
 func (c *Camera) updateDrag() {
 	if inpututil.IsMouseButtonJustPressed(ebiten.MouseButtonLeft) {
 		x, y := ebiten.CursorPosition()
@@ -0,0 +1,168 @@
+package game
+
+import (
+	"fmt"
+	"image/color"
+	"time"
+
+	"github.com/ebitenui/ebitenui"
+	"github.com/ebitenui/ebitenui/widget"
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
+	"github.com/hajimehoshi/ebiten/v2/text"
+
+	"github.com/demonodojo/rabbits/assets"
+	"github.com/demonodojo/rabbits/game/elements/widgets"
+	"github.com/demonodojo/rabbits/game/protocol"
+)
+
+const (
+	chatVisibleLines = 8
+	chatHistory      = 50
+	chatLineLife     = 10 * time.Second
+	chatLineHeight   = 20
+)
+
+var (
+	chatRoomColor   = color.White
+	chatTeamColor   = color.NRGBA{0x8f, 0xe3, 0x88, 0xff}
+	chatSystemColor = color.NRGBA{0xff, 0xd8, 0x6b, 0xff}
+)
+
+type chatLine struct {
+	text  string
+	color color.Color
+	at    time.Time
+}
+
+// ChatOverlay es el chat de ClientScene: pinta los últimos mensajes encima
+// del juego y, con Enter, abre una caja de texto para escribir. Mientras se
+// escribe, Tab cambia entre el canal de la sala y el del equipo, Enter envía
+// y Esc cancela. El teclado es del chat hasta que se cierra.
+type ChatOverlay struct {
+	ui     *ebitenui.UI
+	input  *widget.TextInput
+	lines  []chatLine
+	typing bool
+	team   bool // escribir en el canal de equipo
+	send   func(*protocol.Chat)
+}
+
+// NewChatOverlay crea el chat; send manda al servidor lo que se escriba.
+func NewChatOverlay(send func(*protocol.Chat)) *ChatOverlay {
+	root := widget.NewContainer(
+		widget.ContainerOpts.Layout(widget.NewAnchorLayout(
+			widget.AnchorLayoutOpts.Padding(widget.Insets{Left: 10, Bottom: 45}),
+		)),
+	)
+	box := widget.NewContainer(
+		widget.ContainerOpts.Layout(widget.NewRowLayout(
+			widget.RowLayoutOpts.Direction(widget.DirectionVertical),
+		)),
+		widget.ContainerOpts.WidgetOpts(
+			widget.WidgetOpts.LayoutData(widget.AnchorLayoutData{
+				HorizontalPosition: widget.AnchorLayoutPositionStart,
+				VerticalPosition:   widget.AnchorLayoutPositionEnd,
+			}),
+			widget.WidgetOpts.MinSize(420, 0),
+		),
+	)
+	input := widgets.NewTextInput("Say something", "")
+	box.AddChild(input)
+	root.AddChild(box)
+
+	return &ChatOverlay{
+		// Tab cambia de canal: no debe mover el foco fuera de la caja
+		ui:    &ebitenui.UI{Container: root, DisableDefaultFocus: true},
+		input: input,
+		send:  send,
+	}
+}
+
+// Typing dice si el jugador está escribiendo. Mientras tanto la escena no
+// debe leer el teclado para otra cosa.
+func (o *ChatOverlay) Typing() bool {
+	return o.typing
+}
+
+// Update abre, cierra y envía según el teclado.
+func (o *ChatOverlay) Update() {
+	if !o.typing {
+		if inpututil.IsKeyJustPressed(ebiten.KeyEnter) {
+			o.typing = true
+			o.input.SetText("")
+			o.input.Focus(true)
+		}
+		return
+	}
+
+	o.ui.Update()
+	switch {
+	case inpututil.IsKeyJustPressed(ebiten.KeyTab):
+		o.team = !o.team
+	case inpututil.IsKeyJustPressed(ebiten.KeyEscape):
+		o.close()
+	case inpututil.IsKeyJustPressed(ebiten.KeyEnter):
+		if message := o.input.GetText(); message != "" {
+			channel := protocol.ChannelRoom
+			if o.team {
+				channel = protocol.ChannelTeam
+			}
+			o.send(&protocol.Chat{Channel: channel, Text: message})
+		}
+		o.close()
+	}
+}
+
+func (o *ChatOverlay) close() {
+	o.typing = false
+	o.input.Focus(false)
+	o.input.SetText("")
+}
+
+// Push añade un mensaje recibido del servidor.
+func (o *ChatOverlay) Push(message *protocol.ChatMessage) {
+	line := chatLine{at: time.Now()}
+	switch message.Channel {
+	case protocol.ChannelSystem:
+		line.text = "* " + message.Text
+		line.color = chatSystemColor
+	case protocol.ChannelTeam:
+		line.text = fmt.Sprintf("[equipo] %s: %s", message.Name, message.Text)
+		line.color = chatTeamColor
+	default:
+		line.text = fmt.Sprintf("%s: %s", message.Name, message.Text)
+		line.color = chatRoomColor
+	}
+	o.lines = append(o.lines, line)
+	if len(o.lines) > chatHistory {
+		o.lines = o.lines[len(o.lines)-chatHistory:]
+	}
+}
+
+// Draw pinta los últimos mensajes, de abajo arriba. Los antiguos se
+// esconden salvo mientras se escribe.
+func (o *ChatOverlay) Draw(screen *ebiten.Image) {
+	y := screenHeight - 90
+	if o.typing {
+		o.ui.Draw(screen)
+		channel := "Sala"
+		if o.team {
+			channel = "Equipo"
+		}
+		prompt := fmt.Sprintf("%s  (Enter envía, Tab cambia de canal, Esc cancela)", channel)
+		text.Draw(screen, prompt, assets.InfoFont, 10, y, color.White)
+		y -= chatLineHeight
+	}
+
+	shown := 0
+	for i := len(o.lines) - 1; i >= 0 && shown < chatVisibleLines; i-- {
+		line := o.lines[i]
+		if !o.typing && time.Since(line.at) > chatLineLife {
+			break
+		}
+		text.Draw(screen, line.text, assets.InfoFont, 10, y, line.color)
+		y -= chatLineHeight
+		shown++
+	}
+}
@@ -1,18 +1,26 @@
 package game
 
 import (
-	"encoding/json"
 	"fmt"
 	"image/color"
 	"log"
+	"sync"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
 	"github.com/hajimehoshi/ebiten/v2/text"
 	"golang.org/x/image/math/f64"
 
 	"github.com/demonodojo/rabbits/assets"
 	"github.com/demonodojo/rabbits/game/network"
+	"github.com/demonodojo/rabbits/game/protocol"
+	"github.com/demonodojo/rabbits/game/sim"
+)
+
+const (
+	maxPendingInputs = 120
 )
 
 type ClientScene struct {
@@ -26,6 +34,32 @@ type ClientScene struct {
 	rabbitsOrder  []uuid.UUID
 	lettucesOrder []uuid.UUID
 	bulletsOrder  []uuid.UUID
+	inputSequence uint32
+	pendingInputs []Input
+	sequence      uint32
+	status        string
+	name          string
+	team          string
+	room          string
+	token         string // para recuperar el conejo si se reconecta
+	tokenMutex    sync.Mutex
+	joined        bool
+	history       map[uuid.UUID]*SnapshotBuffer
+	snapshots     map[uint64]*sim.Snapshot
+	lastSnapshot  uint64
+	ticks         TickBuffer
+	viewTick      uint64    // tick del servidor en el que se dibujan los demás
+	spectator     bool      // solo mira; s.rabbit es entonces lo que sigue la cámara sin conejo
+	follow        uuid.UUID // conejo al que sigue la cámara del espectador
+	chat          *ChatOverlay
+
+	// InterpolationDelay es cuánto en el pasado se dibujan las entidades
+	// remotas. Cuanto mayor, más suave pero con más retraso.
+	InterpolationDelay time.Duration
+
+	// OnLeave, si no es nil, se llama cuando el jugador deja la partida con
+	// Esc, después de cerrar la conexión.
+	OnLeave func()
 
 	score         int
 	scale         float64
@@ -33,13 +67,35 @@ type ClientScene struct {
 	velocityTimer *Timer
 }
 
-func NewClientScene(g *Game, client network.GenericClient) *ClientScene {
+// NewClientScene entra en la sala room del servidor de client con el nombre
+// dado. Si el nombre está vacío el servidor elige uno, y si la sala está
+// vacía se entra en la sala por defecto. team es el equipo con el que se
+// comparte el chat de equipo; vacío es sin equipo.
+func NewClientScene(g *Game, client network.GenericClient, name string, team string, room string) *ClientScene {
+	return newClientScene(g, client, name, team, room, false)
+}
+
+// NewSpectatorScene mira la sala room del servidor de client sin jugar. Tab
+// cambia el conejo al que sigue la cámara.
+func NewSpectatorScene(g *Game, client network.GenericClient, room string) *ClientScene {
+	return newClientScene(g, client, "", "", room, true)
+}
+
+func newClientScene(g *Game, client network.GenericClient, name string, team string, room string, spectator bool) *ClientScene {
 	s := &ClientScene{
 		game:          g,
+		name:          name,
+		team:          team,
+		room:          room,
 		camera:        &Camera{ViewPort: f64.Vec2{screenWidth, screenHeight}},
 		client:        client,
 		baseVelocity:  baseMeteorVelocity,
 		velocityTimer: NewTimer(meteorSpeedUpTime),
+		history:       make(map[uuid.UUID]*SnapshotBuffer),
+		snapshots:     make(map[uint64]*sim.Snapshot),
+		spectator:     spectator,
+
+		InterpolationDelay: defaultInterpolationDelay,
 	}
 
 	s.camera.Reset()
@@ -47,47 +103,108 @@ func NewClientScene(g *Game, client network.GenericClient) *ClientScene {
 	s.lettuces = make(map[uuid.UUID]*Lettuce)
 	s.bullets = make(map[uuid.UUID]*Bullet)
 	s.rabbit = NewRabbit(g)
-	s.rabbits[s.rabbit.ID] = s.rabbit
+	if !spectator {
+		s.rabbits[s.rabbit.ID] = s.rabbit
+	}
 
 	s.UpdateRabbitsOrder()
 	s.UpdateLettucesOrder()
 	s.UpdateBulletsOrder()
-
-	client.Write(s.rabbit.ToJson())
+	s.chat = NewChatOverlay(func(chat *protocol.Chat) {
+		s.client.Write(s.encode(chat))
+	})
+
+	// Lo primero es saludar con nuestra versión del protocolo, también tras
+	// cada reconexión; hasta que el servidor nos dé nuestro conejo no se
+	// manda ningún comando
+	s.status = "Conectando..."
+	client.SetHandshake(s.hello)
 	return s
 }
 
-func (s *ClientScene) Update() error {
+// hello es el saludo que manda el cliente al (re)conectar. Lo llama la
+// goroutine de escritura del cliente, por eso no toca más estado que el token.
+func (s *ClientScene) hello() string {
+	s.tokenMutex.Lock()
+	hello := &protocol.Hello{Version: protocol.Version, Name: s.name, Token: s.token, Room: s.room, Spectate: s.spectator, Team: s.team}
+	s.tokenMutex.Unlock()
+	message, err := protocol.EncodeAs(s.client.Encoding(), hello, 0, 0)
+	if err != nil {
+		log.Println(err)
+	}
+	return message
+}
 
-	if s.rabbit.Interact() {
-		s.client.Write(s.rabbit.ToJson())
-		s.rabbit.Action = "NONE"
+// CheckConnection muestra el estado de la conexión y deja de mandar comandos
+// mientras no hay conexión; al volver, el saludo con el token recupera
+// nuestro conejo.
+func (s *ClientScene) CheckConnection() {
+	switch state := s.client.State(); state {
+	case network.StateConnected:
+		return
+	case network.StateReconnecting:
+		s.status = "Conexión perdida, reconectando..."
+	case network.StateClosed:
+		if s.joined || s.status == "" {
+			s.status = "Desconectado"
+		}
+	default:
+		s.status = fmt.Sprintf("Conexión %s", state)
 	}
+	s.joined = false
+}
 
-	s.UpdateRabbits()
+func (s *ClientScene) Update() error {
 
-	for _, r := range s.rabbits {
-		r.Update()
+	s.CheckConnection()
+	// El Esc que cierra el chat no deja además la partida
+	wasTyping := s.chat.Typing()
+	s.chat.Update()
+	typing := s.chat.Typing()
+	if s.OnLeave != nil && !wasTyping && !typing && inpututil.IsKeyJustPressed(ebiten.KeyEscape) {
+		s.client.Close()
+		s.OnLeave()
+		return nil
+	}
+	if s.joined && !s.spectator {
+		s.PredictRabbit()
+	}
+	if s.spectator && !typing && inpututil.IsKeyJustPressed(ebiten.KeyTab) {
+		s.FollowNext()
 	}
 
-	s.camera.Update(s.rabbit)
+	s.UpdateRabbits()
+	at := time.Now().Add(-s.InterpolationDelay)
+	s.Interpolate(at)
+	s.viewTick = s.ticks.Sample(at)
+
+	if typing {
+		s.camera.Follow(s.Followed())
+	} else {
+		s.camera.Update(s.Followed())
+	}
 
 	for _, l := range s.lettuces {
 		l.Update()
 		if l.Action == "Delete" {
 			delete(s.lettuces, l.ID)
+			delete(s.history, l.ID)
 			s.UpdateLettucesOrder()
 		}
 	}
 
 	for _, b := range s.bullets {
-		b.Update()
 		if b.Action == "DELETE" {
 			delete(s.bullets, b.ID)
+			delete(s.history, b.ID)
 			s.UpdateBulletsOrder()
 		}
 	}
 
+	if typing {
+		return nil
+	}
+
 	if ebiten.IsKeyPressed(ebiten.Key1) {
 		ebiten.SetFullscreen(true)
 	}
@@ -121,8 +238,20 @@ func (g *ClientScene) Draw(screen *ebiten.Image) {
 		l.Draw(screen, g.camera.Matrix)
 	}
 
-	text.Draw(screen, fmt.Sprintf("%06d", g.rabbit.Score), assets.ScoreFont, screenWidth/2-100, 50, color.White)
+	followed := g.Followed()
+	text.Draw(screen, fmt.Sprintf("%06d", followed.Score), assets.ScoreFont, screenWidth/2-100, 50, color.White)
+	if g.spectator && followed != g.rabbit {
+		text.Draw(screen, fmt.Sprintf("Mirando a %s", followed.Name), assets.InfoFont, 10, 80, color.White)
+	}
 	text.Draw(screen, fmt.Sprintf("%06d", len(g.bullets)), assets.InfoFont, 10, 50, color.White)
+	if latency := g.client.Latency(); latency.Samples > 0 {
+		ping := fmt.Sprintf("RTT %dms ±%dms", latency.RTT.Milliseconds(), latency.Jitter.Milliseconds())
+		text.Draw(screen, ping, assets.InfoFont, screenWidth-160, 50, color.White)
+	}
+	g.chat.Draw(screen)
+	if g.status != "" {
+		text.Draw(screen, g.status, assets.InfoFont, 10, screenHeight-20, color.White)
+	}
 }
 
 func (g *ClientScene) Reset() {
@@ -144,78 +273,332 @@ func (s *ClientScene) SpawnElement(name string, element interface{}) {
 func (s *ClientScene) UpdateRabbits() {
 
 	messages := s.client.ReadAll()
+	now := time.Now()
 
 	for _, m := range messages {
-		jsonData := []byte(m)
-		var serial Serial
-		if err := json.Unmarshal(jsonData, &serial); err != nil {
-			log.Fatal(fmt.Errorf("cannot unmarshal %s", m))
+		env, value, err := protocol.Decode(m)
+		if err != nil {
+			log.Printf("cannot decode %s: %v", m, err)
 			continue
 		}
-		switch serial.ClassName {
-		case "Rabbit":
-			var rabbit Rabbit
-			if err := json.Unmarshal(jsonData, &rabbit); err != nil {
-				log.Fatal(fmt.Errorf("cannot unmarshal the Rabbit %s", m))
-				continue
-			}
-			var existing *Rabbit
-			if s.rabbit.ID == rabbit.ID {
-				existing = s.rabbit
-				s.rabbit.Score = rabbit.Score
-				s.rabbit.Speed = rabbit.Speed
-				if EuclidianDistance(rabbit.Position, s.rabbit.Position) > 100.0 {
-					s.rabbit.Position = rabbit.Position
-				}
-				continue
-			} else {
-				existing = s.rabbits[rabbit.ID]
-			}
-			if existing != nil {
-				existing.CopyFrom(&rabbit)
-			} else {
-				newRabbit := NewRabbit(s.game)
-				newRabbit.CopyFrom(&rabbit)
-				s.rabbits[rabbit.ID] = newRabbit
-				s.UpdateRabbitsOrder()
-			}
-
-		case "Lettuce":
-			var lettuce Lettuce
-			if err := json.Unmarshal(jsonData, &lettuce); err != nil {
-				log.Fatal(fmt.Errorf("cannot unmarshal the Lettuce %s", m))
-				continue
-			}
-			existing := s.lettuces[lettuce.ID]
-			if existing != nil {
-				existing.CopyFrom(&lettuce)
-			} else {
-				l := NewLettuce()
-				l.CopyFrom(&lettuce)
-				s.lettuces[lettuce.ID] = l
-				s.UpdateLettucesOrder()
-			}
-
-		case "Bullet":
-			var bullet Bullet
-			if err := json.Unmarshal(jsonData, &bullet); err != nil {
-				log.Fatal(fmt.Errorf("cannot unmarshal the Bullet %s", m))
-				continue
-			}
-			existing := s.bullets[bullet.ID]
-			if existing != nil {
-				existing.CopyFrom(&bullet)
-			} else {
-				b := NewBullet(bullet.Position, bullet.Rotation)
-				b.CopyFrom(&bullet)
-				s.bullets[bullet.ID] = b
-				s.UpdateBulletsOrder()
-			}
+		if !protocol.Supported(env.Version) {
+			log.Printf("ignoring message with protocol version %d", env.Version)
+			continue
+		}
+		switch v := value.(type) {
+		case *protocol.Welcome:
+			s.Join(v)
+
+		case *protocol.Reject:
+			log.Printf("rejected by server: %s", v.Reason)
+			s.status = fmt.Sprintf("Rechazado por el servidor: %s", v.Reason)
+			// Reintentar no cambiaría la respuesta
+			s.client.Close()
+			s.joined = false
+
+		case *protocol.LobbyError:
+			// Seguimos conectados, pero en el vestíbulo
+			log.Printf("lobby: %s", v.Reason)
+			s.status = fmt.Sprintf("No se pudo entrar: %s", v.Reason)
+
+		case *protocol.ChatMessage:
+			s.chat.Push(v)
+
+		case *protocol.Leave:
+			s.RemoveRabbit(v.ID)
+			log.Printf("%s left: %s", v.Name, v.Reason)
+
+		case *sim.SnapshotDelta:
+			s.ApplyDelta(now, v)
+
+		case *sim.Rabbit:
+			s.UpdateRabbit(now, v)
+
+		case *sim.Lettuce:
+			s.UpdateLettuce(now, v)
+
+		case *sim.Bullet:
+			s.UpdateBullet(now, v)
 
 		default:
-			log.Printf("cannot unmarshal the Message %s", m)
+			log.Printf("ignoring message %s", env.Type)
+		}
+	}
+}
+
+// Join adopta el conejo que nos ha asignado el servidor. Un espectador no
+// tiene conejo que adoptar.
+func (s *ClientScene) Join(welcome *protocol.Welcome) {
+	// La partida puede ser otra, o la misma vuelta a empezar: sus ticks no
+	// tienen nada que ver con los que ya teníamos
+	s.resetSnapshots()
+	if welcome.Spectator {
+		s.tokenMutex.Lock()
+		if welcome.Room != "" {
+			s.room = welcome.Room
+		}
+		s.tokenMutex.Unlock()
+		s.joined = true
+		s.status = ""
+		return
+	}
+	delete(s.rabbits, s.rabbit.ID)
+	s.rabbit.ID = welcome.ID
+	s.rabbit.Name = welcome.Name
+	s.rabbit.Position = Vector{X: welcome.SpawnX, Y: welcome.SpawnY}
+	// Un conejo nuevo empieza a contar comandos desde cero, y uno recuperado
+	// desde el último que aplicó el servidor
+	s.inputSequence = welcome.LastInput
+	s.rabbit.LastInput = s.inputSequence
+	s.pendingInputs = nil
+	s.rabbits[s.rabbit.ID] = s.rabbit
+	s.UpdateRabbitsOrder()
+
+	s.tokenMutex.Lock()
+	s.token = welcome.Token
+	if welcome.Room != "" {
+		s.room = welcome.Room
+	}
+	s.tokenMutex.Unlock()
+	s.joined = true
+	s.status = ""
+}
+
+// Followed es el conejo al que sigue la cámara: el nuestro, o el que haya
+// elegido el espectador.
+func (s *ClientScene) Followed() *Rabbit {
+	if r := s.rabbits[s.follow]; s.spectator && r != nil {
+		return r
+	}
+	return s.rabbit
+}
+
+// FollowNext pasa la cámara del espectador al siguiente conejo, en orden de
+// ID, y la engancha a él como hace la tecla O.
+func (s *ClientScene) FollowNext() {
+	if len(s.rabbitsOrder) == 0 {
+		return
+	}
+	next := s.rabbitsOrder[0]
+	for i, id := range s.rabbitsOrder {
+		if id == s.follow && i+1 < len(s.rabbitsOrder) {
+			next = s.rabbitsOrder[i+1]
 		}
 	}
+	s.follow = next
+	s.camera.attached = true
+}
+
+// RemoveRabbit quita el conejo de un jugador que se ha ido.
+func (s *ClientScene) RemoveRabbit(id uuid.UUID) {
+	if id == s.rabbit.ID {
+		return
+	}
+	delete(s.rabbits, id)
+	delete(s.history, id)
+	s.UpdateRabbitsOrder()
+}
+
+func (s *ClientScene) UpdateRabbit(now time.Time, v *sim.Rabbit) {
+	if s.rabbit.ID == v.ID {
+		s.Reconcile(v)
+		return
+	}
+	s.pushSnapshot(now, v.ID, v.Position, v.Rotation)
+	if existing := s.rabbits[v.ID]; existing != nil {
+		existing.CopyFrom(v)
+	} else {
+		s.rabbits[v.ID] = WrapRabbit(s.game, v)
+		s.UpdateRabbitsOrder()
+	}
+}
+
+func (s *ClientScene) UpdateLettuce(now time.Time, v *sim.Lettuce) {
+	s.pushSnapshot(now, v.ID, v.Position, 0)
+	if existing := s.lettuces[v.ID]; existing != nil {
+		existing.CopyFrom(v)
+	} else {
+		s.lettuces[v.ID] = WrapLettuce(v)
+		s.UpdateLettucesOrder()
+	}
+}
+
+func (s *ClientScene) UpdateBullet(now time.Time, v *sim.Bullet) {
+	s.pushSnapshot(now, v.ID, v.Position, v.Rotation)
+	if existing := s.bullets[v.ID]; existing != nil {
+		existing.CopyFrom(v)
+	} else {
+		s.bullets[v.ID] = WrapBullet(v)
+		s.UpdateBulletsOrder()
+	}
+}
+
+// ApplyDelta reconstruye el snapshot del servidor a partir del delta y del
+// snapshot contra el que se calculó, lo aplica a la escena y lo confirma. Si
+// no tenemos la base se descarta: el servidor seguirá usando la última
+// confirmada. Un snapshot completo se aplica siempre, aunque su tick sea
+// anterior: el servidor ha vuelto a empezar.
+func (s *ClientScene) ApplyDelta(now time.Time, delta *sim.SnapshotDelta) {
+	if delta.Tick <= s.lastSnapshot && len(s.snapshots) > 0 {
+		if !delta.Full {
+			return
+		}
+		s.resetSnapshots()
+	}
+	var base *sim.Snapshot
+	if !delta.Full {
+		base = s.snapshots[delta.Base]
+		if base == nil {
+			log.Printf("missing base snapshot %d for %d", delta.Base, delta.Tick)
+			return
+		}
+	}
+	snapshot := delta.Apply(base)
+	s.snapshots[snapshot.Tick] = snapshot
+	s.lastSnapshot = snapshot.Tick
+	s.ticks.Push(now, snapshot.Tick)
+	for tick := range s.snapshots {
+		if tick+sim.SnapshotHistory < snapshot.Tick {
+			delete(s.snapshots, tick)
+		}
+	}
+
+	s.ApplySnapshot(now, snapshot)
+	s.client.Write(s.encode(&sim.SnapshotAck{Tick: snapshot.Tick}))
+}
+
+// resetSnapshots olvida los snapshots recibidos y los ticks con los que se
+// interpola, para empezar de cero con el siguiente snapshot completo.
+func (s *ClientScene) resetSnapshots() {
+	s.snapshots = make(map[uint64]*sim.Snapshot)
+	s.lastSnapshot = 0
+	s.ticks = TickBuffer{}
+	s.viewTick = 0
+}
+
+// ApplySnapshot deja la escena como el snapshot: actualiza lo que hay y quita
+// lo que ya no existe en el servidor.
+func (s *ClientScene) ApplySnapshot(now time.Time, snapshot *sim.Snapshot) {
+	for _, id := range sim.OrderedIds(snapshot.Rabbits) {
+		r := snapshot.Rabbits[id]
+		s.UpdateRabbit(now, &r)
+	}
+	for _, id := range sim.OrderedIds(snapshot.Lettuces) {
+		l := snapshot.Lettuces[id]
+		s.UpdateLettuce(now, &l)
+	}
+	for _, id := range sim.OrderedIds(snapshot.Bullets) {
+		b := snapshot.Bullets[id]
+		s.UpdateBullet(now, &b)
+	}
+
+	for id := range s.rabbits {
+		if _, ok := snapshot.Rabbits[id]; !ok {
+			s.RemoveRabbit(id)
+		}
+	}
+	for id := range s.lettuces {
+		if _, ok := snapshot.Lettuces[id]; !ok {
+			delete(s.lettuces, id)
+			delete(s.history, id)
+		}
+	}
+	for id := range s.bullets {
+		if _, ok := snapshot.Bullets[id]; !ok {
+			delete(s.bullets, id)
+			delete(s.history, id)
+		}
+	}
+	s.UpdateRabbitsOrder()
+	s.UpdateLettucesOrder()
+	s.UpdateBulletsOrder()
+}
+
+// encode mete un mensaje en un sobre del protocolo, con la codificación de
+// la conexión. El tick del sobre es el que tendrá el servidor cuando llegue.
+func (s *ClientScene) encode(v interface{}) string {
+	s.sequence++
+	tick := s.ticks.Predict(time.Now(), s.client.Latency().RTT/2)
+	message, err := protocol.EncodeAs(s.client.Encoding(), v, s.sequence, tick)
+	if err != nil {
+		log.Println(err)
+	}
+	return message
+}
+
+func (s *ClientScene) pushSnapshot(at time.Time, id uuid.UUID, position Vector, rotation float64) {
+	buffer := s.history[id]
+	if buffer == nil {
+		buffer = NewSnapshotBuffer()
+		s.history[id] = buffer
+	}
+	buffer.Push(at, position, rotation)
+}
+
+// Interpolate coloca las entidades remotas donde estaban en el instante at,
+// interpolando entre los estados recibidos del servidor. El conejo local no
+// se toca: ese lo lleva la predicción.
+func (s *ClientScene) Interpolate(at time.Time) {
+	for id, r := range s.rabbits {
+		if r == s.rabbit {
+			continue
+		}
+		if buffer := s.history[id]; buffer != nil {
+			r.Position, r.Rotation, _ = buffer.Sample(at)
+		}
+	}
+	for id, b := range s.bullets {
+		if buffer := s.history[id]; buffer != nil {
+			b.Position, b.Rotation, _ = buffer.Sample(at)
+		}
+	}
+	for id, l := range s.lettuces {
+		if buffer := s.history[id]; buffer != nil {
+			l.Position, _, _ = buffer.Sample(at)
+		}
+	}
+}
+
+// PredictRabbit lee el teclado, aplica el comando al conejo local sin esperar
+// al servidor y lo guarda hasta que el servidor confirme que lo ha procesado.
+// Se manda un comando por tick aunque no se pulse nada, porque cada comando es
+// un tick de simulación en el servidor, y mientras se escribe en el chat se
+// manda un comando vacío. Los disparos llevan el tick que se está viendo,
+// para que el servidor los compruebe contra lo mismo.
+func (s *ClientScene) PredictRabbit() {
+	s.inputSequence++
+	in := ReadInput(s.rabbit.ID, s.inputSequence)
+	if s.chat.Typing() {
+		in = NewInput(s.rabbit.ID, s.inputSequence)
+	}
+	if in.Fire {
+		in.ViewTick = s.viewTick
+	}
+	s.rabbit.Simulate(in)
+	s.rabbit.Action = "NONE"
+
+	s.pendingInputs = append(s.pendingInputs, in)
+	if len(s.pendingInputs) > maxPendingInputs {
+		s.pendingInputs = s.pendingInputs[len(s.pendingInputs)-maxPendingInputs:]
+	}
+	s.client.Write(s.encode(in))
+}
+
+// Reconcile vuelve al estado autoritativo que manda el servidor y reaplica
+// encima los comandos que el servidor todavía no ha procesado.
+func (s *ClientScene) Reconcile(authoritative *sim.Rabbit) {
+	acked := 0
+	for acked < len(s.pendingInputs) && s.pendingInputs[acked].Sequence <= authoritative.LastInput {
+		acked++
+	}
+	s.pendingInputs = s.pendingInputs[acked:]
+
+	s.rabbit.CopyFrom(authoritative)
+	for _, in := range s.pendingInputs {
+		s.rabbit.Simulate(in)
+	}
+	s.rabbit.Action = "NONE"
+	s.rabbit.LastInput = s.inputSequence
 }
 
 func (s *ClientScene) UpdateRabbitsOrder() {
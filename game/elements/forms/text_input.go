@@ -1,75 +1,11 @@
 package forms
 
 import (
-	"fmt"
-
-	"image/color"
-
-	e_image "github.com/ebitenui/ebitenui/image"
 	"github.com/ebitenui/ebitenui/widget"
+
+	"github.com/demonodojo/rabbits/game/elements/widgets"
 )
 
 func NewTextInput(name string, value string) *widget.TextInput {
-
-	// load button text font
-	face, _ := loadFont(20)
-
-	// construct a standard textinput widget
-	standardTextInput := widget.NewTextInput(
-		widget.TextInputOpts.WidgetOpts(
-			//Set the layout information to center the textbox in the parent
-			widget.WidgetOpts.LayoutData(widget.RowLayoutData{
-				Position: widget.RowLayoutPositionCenter,
-				Stretch:  true,
-			}),
-		),
-
-		// Set the keyboard type when opened on mobile devices.
-		//widget.TextInputOpts.MobileInputMode(jsUtil.TEXT),
-
-		//Set the Idle and Disabled background image for the text input
-		//If the NineSlice image has a minimum size, the widget will use that or
-		// widget.WidgetOpts.MinSize; whichever is greater
-		widget.TextInputOpts.Image(&widget.TextInputImage{
-			Idle:     e_image.NewNineSliceColor(color.NRGBA{R: 100, G: 100, B: 100, A: 255}),
-			Disabled: e_image.NewNineSliceColor(color.NRGBA{R: 100, G: 100, B: 100, A: 255}),
-		}),
-
-		//Set the font face and size for the widget
-		widget.TextInputOpts.Face(face),
-
-		//Set the colors for the text and caret
-		widget.TextInputOpts.Color(&widget.TextInputColor{
-			Idle:          color.NRGBA{254, 255, 255, 255},
-			Disabled:      color.NRGBA{R: 200, G: 200, B: 200, A: 255},
-			Caret:         color.NRGBA{254, 255, 255, 255},
-			DisabledCaret: color.NRGBA{R: 200, G: 200, B: 200, A: 255},
-		}),
-
-		//Set how much padding there is between the edge of the input and the text
-		widget.TextInputOpts.Padding(widget.NewInsetsSimple(5)),
-
-		//Set the font and width of the caret
-		widget.TextInputOpts.CaretOpts(
-			widget.CaretOpts.Size(face, 2),
-		),
-
-		//This text is displayed if the input is empty
-		widget.TextInputOpts.Placeholder(name),
-
-		//This is called when the user hits the "Enter" key.
-		//There are other options that can configure this behavior
-		widget.TextInputOpts.SubmitHandler(func(args *widget.TextInputChangedEventArgs) {
-			fmt.Println("Text Submitted: ", args.InputText)
-		}),
-
-		//This is called whenver there is a change to the text
-		widget.TextInputOpts.ChangedHandler(func(args *widget.TextInputChangedEventArgs) {
-			fmt.Println("Text Changed: ", args.InputText)
-		}),
-	)
-	if value != "" {
-		standardTextInput.SetText(value)
-	}
-	return standardTextInput
+	return widgets.NewTextInput(name, value)
 }
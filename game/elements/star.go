@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"image/color"
+	"log"
 	"math/rand"
 
 	"github.com/hajimehoshi/ebiten/v2"
@@ -13,6 +14,7 @@ import (
 	"github.com/demonodojo/rabbits/assets"
 	"github.com/demonodojo/rabbits/game"
 	"github.com/demonodojo/rabbits/game/network"
+	"github.com/demonodojo/rabbits/game/protocol"
 	"github.com/google/uuid"
 )
 
@@ -28,6 +30,10 @@ type Star struct {
 	channel  *network.MessageQueue
 }
 
+func init() {
+	protocol.Register[Star]("Star")
+}
+
 func NewStar(channel *network.MessageQueue, position game.Vector) *Star {
 	names := []string{
 		"Absolutno", "Acamar", "Achernar", "Achird", "Acrab", "Acrux", "Acubens",
@@ -173,7 +179,12 @@ func (l *Star) UnSelect() {
 
 func (l *Star) Edit() {
 	l.Action = "EDIT"
-	l.channel.Enqueue(l.ToJson())
+	message, err := protocol.Encode(l, 0, 0)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	l.channel.Enqueue(message)
 }
 
 func (l *Star) Center() (float64, float64) {
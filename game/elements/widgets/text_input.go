@@ -0,0 +1,80 @@
+package widgets
+
+import (
+	"image/color"
+
+	e_image "github.com/ebitenui/ebitenui/image"
+	"github.com/ebitenui/ebitenui/widget"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/goregular"
+
+	"github.com/golang/freetype/truetype"
+)
+
+// NewTextInput crea una caja de texto con el aspecto de los formularios del
+// juego. placeholder se ve mientras está vacía y value es el texto inicial.
+// opts se añaden detrás de las opciones por defecto, así que pueden cambiar,
+// por ejemplo, dónde se coloca o qué hacer al pulsar Enter.
+func NewTextInput(placeholder string, value string, opts ...widget.TextInputOpt) *widget.TextInput {
+	face, _ := LoadFont(20)
+
+	opts = append([]widget.TextInputOpt{
+		widget.TextInputOpts.WidgetOpts(
+			//Set the layout information to center the textbox in the parent
+			widget.WidgetOpts.LayoutData(widget.RowLayoutData{
+				Position: widget.RowLayoutPositionCenter,
+				Stretch:  true,
+			}),
+		),
+
+		//Set the Idle and Disabled background image for the text input
+		//If the NineSlice image has a minimum size, the widget will use that or
+		// widget.WidgetOpts.MinSize; whichever is greater
+		widget.TextInputOpts.Image(&widget.TextInputImage{
+			Idle:     e_image.NewNineSliceColor(color.NRGBA{R: 100, G: 100, B: 100, A: 255}),
+			Disabled: e_image.NewNineSliceColor(color.NRGBA{R: 100, G: 100, B: 100, A: 255}),
+		}),
+
+		//Set the font face and size for the widget
+		widget.TextInputOpts.Face(face),
+
+		//Set the colors for the text and caret
+		widget.TextInputOpts.Color(&widget.TextInputColor{
+			Idle:          color.NRGBA{254, 255, 255, 255},
+			Disabled:      color.NRGBA{R: 200, G: 200, B: 200, A: 255},
+			Caret:         color.NRGBA{254, 255, 255, 255},
+			DisabledCaret: color.NRGBA{R: 200, G: 200, B: 200, A: 255},
+		}),
+
+		//Set how much padding there is between the edge of the input and the text
+		widget.TextInputOpts.Padding(widget.NewInsetsSimple(5)),
+
+		//Set the font and width of the caret
+		widget.TextInputOpts.CaretOpts(
+			widget.CaretOpts.Size(face, 2),
+		),
+
+		//This text is displayed if the input is empty
+		widget.TextInputOpts.Placeholder(placeholder),
+	}, opts...)
+
+	input := widget.NewTextInput(opts...)
+	if value != "" {
+		input.SetText(value)
+	}
+	return input
+}
+
+// LoadFont devuelve la fuente de los formularios al tamaño dado.
+func LoadFont(size float64) (font.Face, error) {
+	ttfFont, err := truetype.Parse(goregular.TTF)
+	if err != nil {
+		return nil, err
+	}
+
+	return truetype.NewFace(ttfFont, &truetype.Options{
+		Size:    size,
+		DPI:     72,
+		Hinting: font.HintingFull,
+	}), nil
+}
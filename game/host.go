@@ -0,0 +1,36 @@
+package game
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/demonodojo/rabbits/game/match"
+	"github.com/demonodojo/rabbits/game/network"
+)
+
+// HostAndPlay sirve las salas de server en segundo plano y entra a jugar en
+// ellas desde este mismo proceso, por un cliente en memoria. Los demás
+// jugadores entran por server como siempre. Las salas corren hasta que se
+// cancele ctx o se llame a stop, que además cierra server y deja libre su
+// puerto para volver a abrirlo.
+func HostAndPlay(ctx context.Context, g *Game, server *network.Server, name string, team string, room string, opts ...network.ClientOption) (scene *ClientScene, stop func()) {
+	ctx, cancel := context.WithCancel(ctx)
+	lobby := match.NewLobby(server)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		lobby.Run(ctx)
+	}()
+
+	stop = func() {
+		cancel()
+		<-done
+		shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), time.Second)
+		defer cancelShutdown()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			log.Println("shutdown:", err)
+		}
+	}
+	return NewClientScene(g, server.Loopback(opts...), name, team, room), stop
+}
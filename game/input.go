@@ -0,0 +1,25 @@
+package game
+
+import (
+	"github.com/google/uuid"
+	"github.com/hajimehoshi/ebiten/v2"
+
+	"github.com/demonodojo/rabbits/game/sim"
+)
+
+type Input = sim.Input
+
+func NewInput(id uuid.UUID, sequence uint32) Input {
+	return sim.NewInput(id, sequence)
+}
+
+// ReadInput lee el teclado y devuelve el comando correspondiente.
+func ReadInput(id uuid.UUID, sequence uint32) Input {
+	in := NewInput(id, sequence)
+	in.Left = ebiten.IsKeyPressed(ebiten.KeyLeft)
+	in.Right = ebiten.IsKeyPressed(ebiten.KeyRight)
+	in.Thrust = ebiten.IsKeyPressed(ebiten.KeyUp)
+	in.Brake = ebiten.IsKeyPressed(ebiten.KeyDown)
+	in.Fire = ebiten.IsKeyPressed(ebiten.KeyF)
+	return in
+}
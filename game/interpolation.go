@@ -0,0 +1,136 @@
+package game
+
+import (
+	"math"
+	"time"
+
+	"github.com/demonodojo/rabbits/game/sim"
+)
+
+const (
+	defaultInterpolationDelay = 100 * time.Millisecond
+	maxSnapshots              = 32
+)
+
+type snapshot struct {
+	at       time.Time
+	position Vector
+	rotation float64
+}
+
+// SnapshotBuffer guarda los últimos estados recibidos de una entidad remota
+// para poder dibujarla interpolada un poco en el pasado.
+type SnapshotBuffer struct {
+	snapshots []snapshot
+}
+
+func NewSnapshotBuffer() *SnapshotBuffer {
+	return &SnapshotBuffer{}
+}
+
+// Push añade un estado recibido en el instante at. Los estados deben llegar en
+// orden; uno anterior al último se descarta.
+func (b *SnapshotBuffer) Push(at time.Time, position Vector, rotation float64) {
+	if n := len(b.snapshots); n > 0 && at.Before(b.snapshots[n-1].at) {
+		return
+	}
+	b.snapshots = append(b.snapshots, snapshot{at: at, position: position, rotation: rotation})
+	if len(b.snapshots) > maxSnapshots {
+		b.snapshots = b.snapshots[len(b.snapshots)-maxSnapshots:]
+	}
+}
+
+// Sample devuelve la posición y rotación interpoladas para el instante at.
+// Antes del primer estado devuelve el primero y después del último el último.
+func (b *SnapshotBuffer) Sample(at time.Time) (Vector, float64, bool) {
+	if len(b.snapshots) == 0 {
+		return Vector{}, 0, false
+	}
+
+	// descarta los estados que ya no hacen falta para interpolar
+	for len(b.snapshots) > 2 && !b.snapshots[1].at.After(at) {
+		b.snapshots = b.snapshots[1:]
+	}
+
+	from := b.snapshots[0]
+	if len(b.snapshots) == 1 || !at.After(from.at) {
+		return from.position, from.rotation, true
+	}
+	to := b.snapshots[1]
+	if !at.Before(to.at) {
+		return to.position, to.rotation, true
+	}
+
+	t := float64(at.Sub(from.at)) / float64(to.at.Sub(from.at))
+	position := Vector{
+		X: from.position.X + (to.position.X-from.position.X)*t,
+		Y: from.position.Y + (to.position.Y-from.position.Y)*t,
+	}
+	return position, lerpAngle(from.rotation, to.rotation, t), true
+}
+
+type tickSample struct {
+	at   time.Time
+	tick uint64
+}
+
+// TickBuffer recuerda cuándo llegó cada snapshot para saber qué tick del
+// servidor se está dibujando en cada instante.
+type TickBuffer struct {
+	samples []tickSample
+}
+
+// Push apunta que el snapshot de tick llegó en el instante at.
+func (b *TickBuffer) Push(at time.Time, tick uint64) {
+	if n := len(b.samples); n > 0 && tick <= b.samples[n-1].tick {
+		return
+	}
+	b.samples = append(b.samples, tickSample{at: at, tick: tick})
+	if len(b.samples) > maxSnapshots {
+		b.samples = b.samples[len(b.samples)-maxSnapshots:]
+	}
+}
+
+// Sample devuelve el tick del servidor que corresponde al instante at,
+// interpolando entre snapshots igual que SnapshotBuffer. Sin snapshots
+// devuelve cero.
+func (b *TickBuffer) Sample(at time.Time) uint64 {
+	if len(b.samples) == 0 {
+		return 0
+	}
+	for len(b.samples) > 2 && !b.samples[1].at.After(at) {
+		b.samples = b.samples[1:]
+	}
+
+	from := b.samples[0]
+	if len(b.samples) == 1 || !at.After(from.at) {
+		return from.tick
+	}
+	to := b.samples[1]
+	if !at.Before(to.at) {
+		return to.tick
+	}
+	t := float64(at.Sub(from.at)) / float64(to.at.Sub(from.at))
+	return from.tick + uint64(math.Round(float64(to.tick-from.tick)*t))
+}
+
+// Predict estima el tick por el que va el servidor en el instante at: el
+// del último snapshot más los ticks que han pasado desde que llegó y ahead,
+// lo que tarda en llegar un mensaje. Sin snapshots devuelve cero.
+func (b *TickBuffer) Predict(at time.Time, ahead time.Duration) uint64 {
+	if len(b.samples) == 0 {
+		return 0
+	}
+	last := b.samples[len(b.samples)-1]
+	elapsed := at.Sub(last.at) + ahead
+	if elapsed <= 0 {
+		return last.tick
+	}
+	return last.tick + uint64(elapsed/sim.TickDuration)
+}
+
+// lerpAngle interpola dos ángulos por el camino más corto.
+func lerpAngle(from, to, t float64) float64 {
+	diff := math.Remainder(to-from, 2*math.Pi)
+	return from + diff*t
+}
@@ -0,0 +1,155 @@
+package match
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/demonodojo/rabbits/game/protocol"
+	"github.com/demonodojo/rabbits/game/sim"
+)
+
+// DefaultMaxChatLength es cuántas letras caben en un mensaje de chat.
+const DefaultMaxChatLength = 200
+
+// ChatFilter limpia lo que escriben los jugadores antes de repartirlo.
+type ChatFilter struct {
+	// MaxLength es cuántas letras caben en un mensaje; lo que sobra se
+	// corta. Con cero no se corta.
+	MaxLength int
+	// Words son las palabras que se tapan con asteriscos. Se comparan
+	// palabras enteras y sin distinguir mayúsculas.
+	Words []string
+}
+
+// DefaultChatFilter corta los mensajes a DefaultMaxChatLength y tapa los
+// tacos más comunes en inglés y en español.
+func DefaultChatFilter() ChatFilter {
+	return ChatFilter{
+		MaxLength: DefaultMaxChatLength,
+		Words: []string{
+			"fuck", "fucking", "shit", "bitch", "cunt", "asshole", "dick",
+			"mierda", "puta", "puto", "joder", "coño", "cabrón", "cabron", "gilipollas",
+		},
+	}
+}
+
+// Filter devuelve text limpio: sin caracteres de control, recortado y con
+// las palabras prohibidas tapadas. Si no queda nada devuelve "".
+func (f ChatFilter) Filter(text string) string {
+	text = cleanText(text, f.MaxLength)
+	var b strings.Builder
+	word := func(r rune) bool { return unicode.IsLetter(r) || unicode.IsDigit(r) }
+	for len(text) > 0 {
+		// Lo que no es palabra pasa tal cual
+		end := strings.IndexFunc(text, word)
+		if end < 0 {
+			end = len(text)
+		}
+		b.WriteString(text[:end])
+		text = text[end:]
+
+		end = strings.IndexFunc(text, func(r rune) bool { return !word(r) })
+		if end < 0 {
+			end = len(text)
+		}
+		if f.banned(text[:end]) {
+			b.WriteString(strings.Repeat("*", utf8.RuneCountInString(text[:end])))
+		} else {
+			b.WriteString(text[:end])
+		}
+		text = text[end:]
+	}
+	return b.String()
+}
+
+func (f ChatFilter) banned(word string) bool {
+	for _, w := range f.Words {
+		if strings.EqualFold(w, word) {
+			return true
+		}
+	}
+	return false
+}
+
+// Chat reparte lo que dice el cliente de conn por el canal que pide: la sala
+// entera o su equipo. Hablar demasiado deprisa cuenta como infracción.
+func (m *Match) Chat(conn *websocket.Conn, chat *protocol.Chat) {
+	p := m.peers[conn]
+	if !p.chats.allow(m.world.Tick) {
+		m.Violation(conn, "chat rate exceeded")
+		return
+	}
+	text := m.ChatFilter.Filter(chat.Text)
+	if text == "" {
+		return
+	}
+	message := &protocol.ChatMessage{From: p.player, Name: p.name, Text: text}
+
+	switch chat.Channel {
+	case "", protocol.ChannelRoom:
+		message.Channel = protocol.ChannelRoom
+		m.Broadcast(message)
+	case protocol.ChannelTeam:
+		if p.team == "" {
+			m.Send(conn, &protocol.ChatMessage{Channel: protocol.ChannelSystem, Text: "You are not in a team"})
+			return
+		}
+		message.Channel = protocol.ChannelTeam
+		m.broadcastTo(message, func(q *peer) bool { return q.team == p.team })
+	default:
+		m.Violation(conn, "unknown chat channel")
+	}
+}
+
+// Announce manda un aviso del servidor a toda la sala.
+func (m *Match) Announce(format string, args ...interface{}) {
+	m.Broadcast(&protocol.ChatMessage{
+		Channel: protocol.ChannelSystem,
+		Text:    fmt.Sprintf(format, args...),
+	})
+}
+
+// AnnounceEvents avisa de los disparos que han dado en el blanco durante el
+// tick y de los cambios de líder.
+func (m *Match) AnnounceEvents(events []sim.Event) {
+	for _, e := range events {
+		if e.Kind != sim.EventRabbitHit {
+			continue
+		}
+		shooter := m.world.Rabbits[e.Bullet.Shooter]
+		if shooter == nil || shooter == e.Rabbit {
+			m.Announce("%s was hit", e.Rabbit.Name)
+			continue
+		}
+		m.Announce("%s hit %s", shooter.Name, e.Rabbit.Name)
+	}
+	m.announceLeader()
+}
+
+// announceLeader avisa cuando un conejo pasa a tener más puntos que todos
+// los demás. Con un solo jugador o con empate no hay líder nuevo.
+func (m *Match) announceLeader() {
+	if len(m.world.Rabbits) < 2 {
+		return
+	}
+	var leader *sim.Rabbit
+	tied := false
+	for _, id := range sim.OrderedIds(m.world.Rabbits) {
+		r := m.world.Rabbits[id]
+		switch {
+		case leader == nil || r.Score > leader.Score:
+			leader, tied = r, false
+		case r.Score == leader.Score:
+			tied = true
+		}
+	}
+	if tied || leader.Score <= 0 || leader.ID == m.leader {
+		return
+	}
+	m.leader = leader.ID
+	m.Announce("%s takes the lead with %d points", leader.Name, leader.Score)
+}
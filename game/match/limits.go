@@ -0,0 +1,101 @@
+package match
+
+import (
+	"math"
+
+	"github.com/demonodojo/rabbits/game/sim"
+)
+
+// Limits acota lo que puede mandar cada cliente. Los ritmos son mensajes por
+// segundo y las ráfagas cuántos se admiten de golpe; un ritmo cero no limita.
+type Limits struct {
+	// MaxMessageSize es el tamaño máximo en bytes de un mensaje.
+	MaxMessageSize int
+
+	MessageRate  float64
+	MessageBurst int
+
+	// InputRate acota los comandos: más de uno por tick aceleraría el conejo.
+	InputRate  float64
+	InputBurst int
+
+	// FireRate acota los comandos con el disparo pulsado. A los que se pasan
+	// se les quita el disparo.
+	FireRate  float64
+	FireBurst int
+
+	// CatchUpRate acota los comandos atrasados que se aplican de más, por
+	// encima del que toca cada tick, para que un conejo se ponga al día
+	// tras un tirón de la red. Con cero nunca se aplica más de uno por tick.
+	CatchUpRate  float64
+	CatchUpBurst int
+
+	// ChatRate acota los mensajes de chat.
+	ChatRate  float64
+	ChatBurst int
+
+	// MaxViolations es cuántas infracciones se toleran de golpe antes de
+	// echar al cliente. Se perdona una por segundo.
+	MaxViolations int
+
+	// MaxInputLead es cuánto puede adelantarse la secuencia de un comando a
+	// la del último procesado.
+	MaxInputLead uint32
+}
+
+// DefaultLimits deja margen de sobra a un cliente normal, que manda un
+// comando por tick y unas pocas confirmaciones de snapshot por segundo.
+func DefaultLimits() Limits {
+	return Limits{
+		MaxMessageSize: 1024,
+		MessageRate:    2 * sim.TickRate,
+		MessageBurst:   sim.TickRate,
+		InputRate:      sim.TickRate,
+		InputBurst:     sim.TickRate / 2,
+		FireRate:       sim.TickRate,
+		FireBurst:      sim.TickRate / 2,
+		CatchUpRate:    sim.TickRate / 10,
+		CatchUpBurst:   3,
+		ChatRate:       1,
+		ChatBurst:      5,
+		MaxViolations:  20,
+		MaxInputLead:   10 * sim.TickRate,
+	}
+}
+
+// tokenBucket es un limitador de ritmo que se rellena con los ticks de la
+// simulación, no con el reloj, para que sea determinista.
+type tokenBucket struct {
+	rate   float64 // fichas por tick
+	burst  float64
+	tokens float64
+	last   uint64
+}
+
+func newTokenBucket(perSecond float64, burst int, tick uint64) *tokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{
+		rate:   perSecond / sim.TickRate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   tick,
+	}
+}
+
+// allow gasta una ficha si la hay.
+func (b *tokenBucket) allow(tick uint64) bool {
+	if b.rate <= 0 {
+		return true
+	}
+	if tick > b.last {
+		b.tokens = math.Min(b.burst, b.tokens+float64(tick-b.last)*b.rate)
+		b.last = tick
+	}
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
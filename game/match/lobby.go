@@ -0,0 +1,455 @@
+package match
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+
+	"github.com/demonodojo/rabbits/game/network"
+	"github.com/demonodojo/rabbits/game/protocol"
+	"github.com/demonodojo/rabbits/game/sim"
+)
+
+const (
+	// DefaultRoom es la sala que existe siempre y a la que va quien saluda
+	// sin elegir sala.
+	DefaultRoom = "main"
+
+	DefaultMaxPlayers    = 16
+	DefaultMaxSpectators = 8
+	DefaultMaxRooms      = 32
+
+	// DefaultEmptyTimeout es cuánto sobrevive vacía una sala creada por los
+	// jugadores antes de cerrarse.
+	DefaultEmptyTimeout = 10 * time.Second
+
+	maxRoomPlayers = 64
+)
+
+// Room es una sala: una partida con su propio mundo y sus jugadores.
+type Room struct {
+	Name       string
+	MaxPlayers int
+	// MaxSpectators es cuántos espectadores caben; con cero no se admiten.
+	MaxSpectators int
+	// Persistent indica que la sala no se cierra aunque se quede vacía.
+	Persistent bool
+	Match      *Match
+
+	lobby      *Lobby
+	messages   []network.PeerMessage
+	events     []network.PeerEvent
+	emptySince uint64 // tick en que se quedó vacía, cero si tiene jugadores
+}
+
+// Watched dice si hay alguien en la sala, jugando o mirando.
+func (r *Room) Watched() bool {
+	return r.Match.Players() > 0 || r.Match.Spectators() > 0
+}
+
+// Full dice si ya no cabe nadie más.
+func (r *Room) Full() bool {
+	return r.Match.Players() >= r.MaxPlayers
+}
+
+func (r *Room) Info() protocol.RoomInfo {
+	return protocol.RoomInfo{
+		Name:       r.Name,
+		Players:    r.Match.Players(),
+		MaxPlayers: r.MaxPlayers,
+		Spectators: r.Match.Spectators(),
+	}
+}
+
+// ReadAll, Events, Bind, Send y Disconnect hacen de Room el Transport de su
+// partida: solo ve los mensajes y eventos de sus conexiones.
+
+func (r *Room) ReadAll() []network.PeerMessage {
+	messages := r.messages
+	r.messages = nil
+	return messages
+}
+
+func (r *Room) Events() []network.PeerEvent {
+	events := r.events
+	r.events = nil
+	return events
+}
+
+func (r *Room) Bind(conn *websocket.Conn, player uuid.UUID) {
+	r.lobby.server.Bind(conn, player)
+}
+
+func (r *Room) Send(conn *websocket.Conn, message string) {
+	r.lobby.server.Send(conn, message)
+}
+
+func (r *Room) Disconnect(conn *websocket.Conn, reason string) {
+	r.lobby.forget(conn)
+	r.lobby.server.Disconnect(conn, reason)
+}
+
+// drop descarta los mensajes de conn que la partida aún no ha leído.
+func (r *Room) drop(conn *websocket.Conn) {
+	kept := r.messages[:0]
+	for _, msg := range r.messages {
+		if msg.Peer != conn {
+			kept = append(kept, msg)
+		}
+	}
+	r.messages = kept
+}
+
+// visitor es lo que el vestíbulo sabe de una conexión, esté o no en una
+// sala.
+type visitor struct {
+	encoding protocol.Encoding
+	messages *tokenBucket
+}
+
+// Lobby reparte las conexiones de un servidor entre varias salas, cada una
+// con su partida. Quien conecta llega al vestíbulo, donde puede listar y
+// crear salas, y entra en una saludando con el nombre de la sala.
+type Lobby struct {
+	server   *network.Server
+	rooms    map[string]*Room
+	conns    map[*websocket.Conn]*Room // sala de cada conexión que juega
+	visitors map[*websocket.Conn]*visitor
+	tick     uint64
+	sequence uint32
+
+	// MaxRooms acota cuántas salas puede haber a la vez.
+	MaxRooms int
+	// DefaultMaxPlayers es el tope de las salas que no piden otro.
+	DefaultMaxPlayers int
+	// DefaultMaxSpectators es el tope de espectadores de las salas nuevas.
+	DefaultMaxSpectators int
+	// EmptyTimeout es cuánto sobrevive vacía una sala no persistente.
+	EmptyTimeout time.Duration
+
+	// SnapshotRate, GracePeriod, MaxRewind, Limits y ChatFilter se aplican a
+	// todas las salas en cada tick, así que se pueden cambiar en cualquier
+	// momento.
+	SnapshotRate int
+	GracePeriod  time.Duration
+	MaxRewind    time.Duration
+	Limits       Limits
+	ChatFilter   ChatFilter
+}
+
+// NewLobby crea un vestíbulo con la sala por defecto ya abierta.
+func NewLobby(server *network.Server) *Lobby {
+	l := &Lobby{
+		server:   server,
+		rooms:    make(map[string]*Room),
+		conns:    make(map[*websocket.Conn]*Room),
+		visitors: make(map[*websocket.Conn]*visitor),
+
+		MaxRooms:             DefaultMaxRooms,
+		DefaultMaxPlayers:    DefaultMaxPlayers,
+		DefaultMaxSpectators: DefaultMaxSpectators,
+		EmptyTimeout:         DefaultEmptyTimeout,
+
+		SnapshotRate: DefaultSnapshotRate,
+		GracePeriod:  DefaultGracePeriod,
+		MaxRewind:    DefaultMaxRewind,
+		Limits:       DefaultLimits(),
+		ChatFilter:   DefaultChatFilter(),
+	}
+	l.OpenRoom(DefaultRoom, DefaultMaxPlayers, true)
+	return l
+}
+
+// OpenRoom abre una sala con una partida nueva. El nombre se limpia y se
+// recorta como el de los jugadores. Con maxPlayers cero se usa
+// DefaultMaxPlayers.
+func (l *Lobby) OpenRoom(name string, maxPlayers int, persistent bool) (*Room, error) {
+	name = cleanName(name)
+	if name == "" {
+		return nil, fmt.Errorf("room name is empty")
+	}
+	if _, ok := l.rooms[name]; ok {
+		return nil, fmt.Errorf("room %s already exists", name)
+	}
+	if len(l.rooms) >= l.MaxRooms && l.MaxRooms > 0 {
+		return nil, fmt.Errorf("too many rooms")
+	}
+	if maxPlayers <= 0 {
+		maxPlayers = l.DefaultMaxPlayers
+	}
+	if maxPlayers > maxRoomPlayers {
+		maxPlayers = maxRoomPlayers
+	}
+
+	room := &Room{
+		Name:          name,
+		MaxPlayers:    maxPlayers,
+		MaxSpectators: l.DefaultMaxSpectators,
+		Persistent:    persistent,
+		lobby:         l,
+	}
+	room.Match = l.newMatch(room)
+	room.emptySince = l.tick
+	l.rooms[name] = room
+	log.Printf("room %s opened", name)
+	return room, nil
+}
+
+func (l *Lobby) newMatch(room *Room) *Match {
+	m := NewMatch(room, time.Now().UnixNano())
+	m.Name = room.Name
+	m.TurnAway = func(conn *websocket.Conn, encoding protocol.Encoding, reason string) {
+		l.turnAway(conn, room, encoding, reason)
+	}
+	l.configure(m, room)
+	return m
+}
+
+// configure pasa a m, la partida de room, los ajustes del vestíbulo y los
+// topes de la sala.
+func (l *Lobby) configure(m *Match, room *Room) {
+	m.MaxPlayers = room.MaxPlayers
+	m.MaxSpectators = room.MaxSpectators
+	m.SnapshotRate = l.SnapshotRate
+	m.GracePeriod = l.GracePeriod
+	m.MaxRewind = l.MaxRewind
+	m.Limits = l.Limits
+	m.ChatFilter = l.ChatFilter
+}
+
+// Room devuelve la sala llamada name, o nil si no existe.
+func (l *Lobby) Room(name string) *Room {
+	return l.rooms[name]
+}
+
+// Rooms devuelve las salas ordenadas por nombre.
+func (l *Lobby) Rooms() []*Room {
+	rooms := make([]*Room, 0, len(l.rooms))
+	for _, room := range l.rooms {
+		rooms = append(rooms, room)
+	}
+	sort.Slice(rooms, func(i, j int) bool { return rooms[i].Name < rooms[j].Name })
+	return rooms
+}
+
+// ResetRoom empieza de cero la partida de una sala. Sus jugadores tienen que
+// volver a saludar.
+func (l *Lobby) ResetRoom(name string) {
+	if room := l.rooms[name]; room != nil {
+		room.Match = l.newMatch(room)
+	}
+}
+
+// Run ejecuta todas las salas con su propio ticker hasta que se cancele ctx.
+func (l *Lobby) Run(ctx context.Context) error {
+	ticker := time.NewTicker(sim.TickDuration)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			l.Update()
+		}
+	}
+}
+
+// Update reparte lo recibido entre las salas, avanza cada partida un tick y
+// cierra las salas que llevan demasiado tiempo vacías.
+func (l *Lobby) Update() {
+	l.tick++
+
+	// Primero los mensajes y luego las salidas, para no volver a apuntar una
+	// conexión que ya se ha olvidado
+	for _, msg := range l.server.ReadAll() {
+		l.route(msg)
+	}
+	for _, e := range l.server.Events() {
+		if room := l.conns[e.Conn]; room != nil {
+			room.events = append(room.events, e)
+		}
+		if e.Kind == network.PeerDisconnected {
+			l.forget(e.Conn)
+		}
+	}
+
+	timeout := uint64(l.EmptyTimeout / sim.TickDuration)
+	for _, room := range l.Rooms() {
+		l.configure(room.Match, room)
+		room.Match.Update()
+
+		if room.Watched() {
+			room.emptySince = 0
+			continue
+		}
+		if room.emptySince == 0 {
+			room.emptySince = l.tick
+		}
+		if !room.Persistent && l.tick-room.emptySince >= timeout {
+			l.closeRoom(room)
+		}
+	}
+
+	// Una vez por segundo basta para quien busca servidores en la red.
+	if l.tick%sim.TickRate == 1 {
+		l.server.SetRooms(l.roomList().Rooms)
+	}
+}
+
+func (l *Lobby) closeRoom(room *Room) {
+	for conn, r := range l.conns {
+		if r == room {
+			l.forget(conn)
+			l.server.Disconnect(conn, "room closed")
+		}
+	}
+	delete(l.rooms, room.Name)
+	log.Printf("room %s closed", room.Name)
+}
+
+// forget olvida una conexión que se ha ido o a la que se ha echado.
+func (l *Lobby) forget(conn *websocket.Conn) {
+	delete(l.conns, conn)
+	delete(l.visitors, conn)
+}
+
+// route lleva un mensaje a la sala de su conexión, salvo los del vestíbulo,
+// que atiende el propio Lobby. Estos se cobran del cubo de mensajes que la
+// conexión tiene en el vestíbulo también mientras está en una sala, porque
+// no pasan por la partida.
+func (l *Lobby) route(msg network.PeerMessage) {
+	env, err := protocol.Peek(msg.Message)
+	v := l.visitors[msg.Peer]
+	if v == nil {
+		v = &visitor{
+			encoding: env.Encoding,
+			messages: newTokenBucket(l.Limits.MessageRate, l.Limits.MessageBurst, l.tick),
+		}
+		l.visitors[msg.Peer] = v
+	}
+
+	if room := l.conns[msg.Peer]; room != nil {
+		if err != nil || (env.Type != "leave_room" && env.Type != "list_rooms") {
+			// Lo demás, incluso lo que no se entiende, lo valida la partida
+			room.messages = append(room.messages, msg)
+			return
+		}
+		if !v.messages.allow(l.tick) {
+			room.Match.Violation(msg.Peer, "message rate exceeded")
+			return
+		}
+		if env.Type == "leave_room" {
+			l.leaveRoom(msg.Peer, room, env.Encoding)
+		} else {
+			l.send(msg.Peer, env.Encoding, l.roomList())
+		}
+		return
+	}
+
+	if len(msg.Message) > l.Limits.MaxMessageSize && l.Limits.MaxMessageSize > 0 {
+		l.reject(msg.Peer, env.Encoding, "message too large")
+		return
+	}
+	if !v.messages.allow(l.tick) {
+		l.reject(msg.Peer, env.Encoding, "message rate exceeded")
+		return
+	}
+	_, value, err := protocol.Decode(msg.Message)
+	if err != nil {
+		log.Printf("cannot decode %q: %v", msg.Message, err)
+		l.reject(msg.Peer, env.Encoding, fmt.Sprintf("unsupported protocol, expected version %d", protocol.Version))
+		return
+	}
+	v.encoding = env.Encoding
+
+	switch value := value.(type) {
+	case *protocol.Hello:
+		l.joinRoom(msg, value, v)
+	case *protocol.ListRooms:
+		l.send(msg.Peer, v.encoding, l.roomList())
+	case *protocol.CreateRoom:
+		if _, err := l.OpenRoom(value.Name, value.MaxPlayers, false); err != nil {
+			l.send(msg.Peer, v.encoding, &protocol.LobbyError{Reason: err.Error()})
+			return
+		}
+		l.send(msg.Peer, v.encoding, l.roomList())
+	default:
+		l.reject(msg.Peer, v.encoding, "join a room first")
+	}
+}
+
+// joinRoom mete la conexión en la sala que pide su saludo y le pasa el
+// saludo a la partida, que es quien la acepta o la rechaza. En una sala
+// llena solo se entra para recuperar un conejo que ya estaba. Los
+// espectadores tienen su propio tope. Aquí solo se descarta a quien seguro
+// no cabe; los topes los hace cumplir la partida, que ve también a los que
+// entran en el mismo tick.
+func (l *Lobby) joinRoom(msg network.PeerMessage, hello *protocol.Hello, v *visitor) {
+	name := hello.Room
+	if name == "" {
+		name = DefaultRoom
+	}
+	room := l.rooms[name]
+	if room == nil {
+		l.send(msg.Peer, v.encoding, &protocol.LobbyError{Reason: fmt.Sprintf("room %s does not exist", name)})
+		return
+	}
+	if hello.Spectate {
+		if room.Match.Spectators() >= room.MaxSpectators {
+			l.send(msg.Peer, v.encoding, &protocol.LobbyError{Reason: fmt.Sprintf("room %s does not admit more spectators", name)})
+			return
+		}
+	} else if room.Full() && !room.Match.HasSession(hello.Token) {
+		l.send(msg.Peer, v.encoding, &protocol.LobbyError{Reason: fmt.Sprintf("room %s is full", name)})
+		return
+	}
+	l.conns[msg.Peer] = room
+	room.messages = append(room.messages, msg)
+}
+
+// turnAway devuelve al vestíbulo una conexión a la que la partida de room no
+// ha dejado entrar.
+func (l *Lobby) turnAway(conn *websocket.Conn, room *Room, encoding protocol.Encoding, reason string) {
+	if l.conns[conn] == room {
+		delete(l.conns, conn)
+	}
+	l.send(conn, encoding, &protocol.LobbyError{Reason: reason})
+}
+
+// leaveRoom devuelve la conexión al vestíbulo y le manda la lista de salas.
+func (l *Lobby) leaveRoom(conn *websocket.Conn, room *Room, encoding protocol.Encoding) {
+	room.drop(conn)
+	room.Match.Leave(conn)
+	delete(l.conns, conn)
+	l.send(conn, encoding, l.roomList())
+}
+
+func (l *Lobby) roomList() *protocol.RoomList {
+	list := &protocol.RoomList{}
+	for _, room := range l.Rooms() {
+		list.Rooms = append(list.Rooms, room.Info())
+	}
+	return list
+}
+
+func (l *Lobby) send(conn *websocket.Conn, encoding protocol.Encoding, v interface{}) {
+	l.sequence++
+	message, err := protocol.EncodeAs(encoding, v, l.sequence, l.tick)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	l.server.Send(conn, message)
+}
+
+func (l *Lobby) reject(conn *websocket.Conn, encoding protocol.Encoding, reason string) {
+	log.Printf("rejecting client: %s", reason)
+	l.send(conn, encoding, &protocol.Reject{Reason: reason})
+	l.forget(conn)
+	l.server.Disconnect(conn, reason)
+}
@@ -0,0 +1,799 @@
+package match
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+
+	"github.com/demonodojo/rabbits/game/network"
+	"github.com/demonodojo/rabbits/game/protocol"
+	"github.com/demonodojo/rabbits/game/sim"
+)
+
+const (
+	maxLettuces = 20
+
+	// DefaultSnapshotRate es cuántos snapshots por segundo se mandan si no se
+	// configura otra cosa.
+	DefaultSnapshotRate = 20
+
+	// DefaultGracePeriod es cuánto se guarda el conejo de un jugador que se ha
+	// desconectado por si vuelve.
+	DefaultGracePeriod = 30 * time.Second
+
+	// DefaultMaxRewind es cuánto en el pasado se comprueban como mucho los
+	// disparos para compensar la latencia de quien dispara.
+	DefaultMaxRewind = 250 * time.Millisecond
+
+	maxNameLength = 16
+)
+
+// peer es lo que la partida sabe de un cliente aceptado.
+type peer struct {
+	encoding protocol.Encoding
+	player   uuid.UUID // conejo que controla
+	acked    uint64    // último snapshot confirmado
+	hasAck   bool
+	synced   bool // ya ha recibido algún snapshot
+	// spectator solo mira: no tiene conejo y no puede mandar comandos
+	spectator bool
+	name      string // con el que firma en el chat
+	team      string // equipo con el que comparte el canal de equipo
+
+	messages  *tokenBucket
+	inputs    *tokenBucket
+	fires     *tokenBucket
+	chats     *tokenBucket
+	catchUp   *tokenBucket // comandos atrasados que se aplican de más
+	tolerance *tokenBucket // infracciones que aún se le perdonan
+}
+
+// Match es la lógica del servidor de una partida: lee los comandos de los
+// clientes, avanza la simulación y difunde el resultado. No depende de Ebiten,
+// así que se puede usar tanto desde ServerScene como desde un servidor sin
+// ventana.
+type Match struct {
+	server   Transport
+	world    *sim.World
+	inputs   map[uuid.UUID][]sim.Input
+	peers    map[*websocket.Conn]*peer
+	sessions map[string]uuid.UUID // token de reconexión -> conejo
+	away     map[uuid.UUID]uint64 // conejos sin conexión -> tick en que se fueron
+	sequence uint32
+
+	// Name es el nombre de la sala que juega esta partida, si la hay. Se le
+	// dice al cliente en el Welcome.
+	Name string
+
+	// GracePeriod es cuánto tiempo se guardan el conejo y la puntuación de un
+	// jugador desconectado para que pueda recuperarlos con su token.
+	GracePeriod time.Duration
+
+	// SnapshotRate es cuántos snapshots del mundo por segundo se mandan a
+	// cada cliente.
+	SnapshotRate int
+	snapshots    map[uint64]*sim.Snapshot
+
+	// MaxRewind es cuánto se puede retroceder para comprobar un disparo
+	// contra lo que veía quien disparó. Con cero los disparos se comprueban
+	// contra el presente.
+	MaxRewind time.Duration
+
+	// Recorder, si no es nil, graba los comandos según se aplican y el mundo
+	// de cada snapshot.
+	Recorder Recorder
+
+	// MaxPlayers y MaxSpectators acotan cuántos jugadores y espectadores
+	// caben en la partida; con cero no hay tope. En una partida llena solo se
+	// entra para recuperar un conejo que ya estaba.
+	MaxPlayers    int
+	MaxSpectators int
+	// TurnAway, si no es nil, se encarga de quien no cabe en vez de echarlo.
+	// El vestíbulo lo usa para devolver la conexión a la lista de salas.
+	TurnAway func(conn *websocket.Conn, encoding protocol.Encoding, reason string)
+
+	// ChatFilter limpia los mensajes de chat antes de repartirlos.
+	ChatFilter ChatFilter
+	leader     uuid.UUID // último conejo anunciado en cabeza
+
+	// Limits acota lo que puede mandar cada cliente.
+	Limits     Limits
+	violations map[string]uint64            // infracciones por tipo
+	rejected   map[*websocket.Conn]struct{} // echados durante este tick
+}
+
+func NewMatch(server Transport, seed int64) *Match {
+	m := &Match{
+		server:   server,
+		world:    sim.NewWorld(seed),
+		inputs:   make(map[uuid.UUID][]sim.Input),
+		peers:    make(map[*websocket.Conn]*peer),
+		sessions: make(map[string]uuid.UUID),
+		away:     make(map[uuid.UUID]uint64),
+
+		GracePeriod: DefaultGracePeriod,
+
+		SnapshotRate: DefaultSnapshotRate,
+		snapshots:    make(map[uint64]*sim.Snapshot),
+
+		MaxRewind: DefaultMaxRewind,
+
+		ChatFilter: DefaultChatFilter(),
+
+		Limits:     DefaultLimits(),
+		violations: make(map[string]uint64),
+		rejected:   make(map[*websocket.Conn]struct{}),
+	}
+	m.world.MaxLettuces = maxLettuces
+	return m
+}
+
+func (m *Match) World() *sim.World {
+	return m.world
+}
+
+// Run ejecuta la partida con su propio ticker hasta que se cancele ctx.
+func (m *Match) Run(ctx context.Context) error {
+	ticker := time.NewTicker(sim.TickDuration)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			m.Update()
+		}
+	}
+}
+
+// Update avanza la partida un tick. Los mensajes se leen antes que las
+// desconexiones: si un cliente saluda y se va en el mismo tick, su salida
+// llega después de su saludo y se lleva el conejo.
+func (m *Match) Update() {
+	m.ReadMessages()
+	m.HandlePeerEvents()
+
+	m.world.MaxRewind = uint64(m.MaxRewind / sim.TickDuration)
+	m.SimulateRabbits()
+	m.AnnounceEvents(m.world.Advance())
+
+	if m.world.Tick%m.snapshotInterval() == 0 {
+		if m.Recorder != nil {
+			m.Recorder.RecordSnapshot(m.world.Snapshot())
+		}
+		m.BroadcastSnapshot()
+	} else {
+		m.SyncNewPeers()
+	}
+}
+
+// ReadMessages recoge los comandos recibidos de los clientes. El servidor
+// nunca acepta el estado de un conejo tal cual: solo sus comandos. Lo primero
+// que debe mandar un cliente es un saludo con su versión del protocolo.
+func (m *Match) ReadMessages() {
+
+	messages := m.server.ReadAll()
+
+	clear(m.rejected)
+	for _, msg := range messages {
+		if _, ok := m.rejected[msg.Peer]; ok {
+			continue
+		}
+		m.ReadMessage(msg)
+	}
+}
+
+// ReadMessage valida y atiende un mensaje. Un mensaje que no se entiende o
+// llega demasiado deprisa cuenta como infracción; un cliente que aún no ha
+// saludado se rechaza a la primera.
+func (m *Match) ReadMessage(msg network.PeerMessage) {
+	defer func() {
+		// Un mensaje no puede tumbar la partida
+		if err := recover(); err != nil {
+			log.Printf("panic handling %q: %v", msg.Message, err)
+			m.Violation(msg.Peer, "malformed message")
+		}
+	}()
+
+	p, joined := m.peers[msg.Peer]
+	if len(msg.Message) > m.Limits.MaxMessageSize && m.Limits.MaxMessageSize > 0 {
+		if !joined {
+			m.Reject(msg.Peer, "message too large")
+			return
+		}
+		m.Violation(msg.Peer, "message too large")
+		return
+	}
+	if joined && !p.messages.allow(m.world.Tick) {
+		m.Violation(msg.Peer, "message rate exceeded")
+		return
+	}
+
+	env, value, err := protocol.Decode(msg.Message)
+	if err != nil {
+		log.Printf("cannot decode %q: %v", msg.Message, err)
+		if !joined {
+			m.Reject(msg.Peer, fmt.Sprintf("unsupported protocol, expected version %d", protocol.Version))
+			return
+		}
+		m.Violation(msg.Peer, "malformed message")
+		return
+	}
+
+	if hello, ok := value.(*protocol.Hello); ok {
+		m.Greet(msg.Peer, hello, env.Encoding)
+		return
+	}
+	if !joined {
+		m.Reject(msg.Peer, "hello expected before any other message")
+		return
+	}
+	if !protocol.Supported(env.Version) {
+		m.Reject(msg.Peer, fmt.Sprintf("protocol version %d not supported", env.Version))
+		return
+	}
+
+	switch v := value.(type) {
+	case *sim.Input:
+		m.QueueInput(msg.Peer, *v)
+	case *sim.SnapshotAck:
+		m.Ack(msg.Peer, v.Tick)
+	case *protocol.Chat:
+		m.Chat(msg.Peer, v)
+	default:
+		log.Printf("unexpected message %s", env.Type)
+		m.Violation(msg.Peer, "unexpected message")
+	}
+}
+
+// Violation apunta una infracción del cliente de conn. Se toleran unas
+// cuantas seguidas, hasta Limits.MaxViolations, y se perdona una por segundo;
+// quien pasa de ahí se queda fuera.
+func (m *Match) Violation(conn *websocket.Conn, kind string) {
+	m.violations[kind]++
+	p := m.peers[conn]
+	if p == nil {
+		return
+	}
+	if p.spectator {
+		log.Printf("spectator in %s: %s", m.Name, kind)
+	} else {
+		log.Printf("player %s: %s", p.player, kind)
+	}
+	if m.Limits.MaxViolations > 0 && !p.tolerance.allow(m.world.Tick) {
+		m.Reject(conn, "too many invalid messages")
+	}
+}
+
+// Violations devuelve cuántas infracciones de cada tipo ha habido.
+func (m *Match) Violations() map[string]uint64 {
+	violations := make(map[string]uint64, len(m.violations))
+	for kind, n := range m.violations {
+		violations[kind] = n
+	}
+	return violations
+}
+
+// Greet responde al saludo de un cliente aceptándolo o rechazándolo según su
+// versión del protocolo. Si trae el token de una sesión cuyo conejo sigue en
+// la partida lo recupera; si no, le crea uno nuevo. El identificador lo decide
+// siempre el servidor. A partir de aquí se le habla en la misma codificación
+// que usó para saludar, y al final del tick recibe el mundo completo.
+func (m *Match) Greet(conn *websocket.Conn, hello *protocol.Hello, encoding protocol.Encoding) {
+	if err := protocol.CheckHello(hello); err != nil {
+		m.Reject(conn, err.Error())
+		return
+	}
+	if _, ok := m.peers[conn]; ok {
+		m.Reject(conn, "already joined")
+		return
+	}
+	if hello.Spectate {
+		m.Spectate(conn, hello, encoding)
+		return
+	}
+
+	token := hello.Token
+	rabbit := m.world.Rabbits[m.sessions[token]]
+	if rabbit == nil && m.MaxPlayers > 0 && m.Players() >= m.MaxPlayers {
+		m.turnAway(conn, encoding, fmt.Sprintf("room %s is full", m.Name))
+		return
+	}
+	if rabbit != nil {
+		m.dropPlayerPeers(rabbit.ID, "session resumed from another connection")
+		delete(m.away, rabbit.ID)
+		// Lo que quedara por aplicar de la conexión anterior se descarta: el
+		// cliente sigue numerando desde LastInput
+		delete(m.inputs, rabbit.ID)
+	} else {
+		rabbit = m.world.SpawnRabbit(cleanName(hello.Name))
+		if rabbit.Name == "" {
+			rabbit.Name = "Rabbit-" + rabbit.ID.String()[:4]
+		}
+		token = newToken()
+		m.sessions[token] = rabbit.ID
+		m.Announce("%s joined", rabbit.Name)
+	}
+
+	tick := m.world.Tick
+	m.peers[conn] = &peer{
+		encoding:  encoding,
+		player:    rabbit.ID,
+		name:      rabbit.Name,
+		team:      cleanName(hello.Team),
+		messages:  newTokenBucket(m.Limits.MessageRate, m.Limits.MessageBurst, tick),
+		inputs:    newTokenBucket(m.Limits.InputRate, m.Limits.InputBurst, tick),
+		fires:     newTokenBucket(m.Limits.FireRate, m.Limits.FireBurst, tick),
+		chats:     newTokenBucket(m.Limits.ChatRate, m.Limits.ChatBurst, tick),
+		catchUp:   newTokenBucket(m.Limits.CatchUpRate, m.Limits.CatchUpBurst, tick),
+		tolerance: newTokenBucket(1, m.Limits.MaxViolations, tick),
+	}
+	m.server.Bind(conn, rabbit.ID)
+	m.Send(conn, &protocol.Welcome{
+		Version:   protocol.Version,
+		ID:        rabbit.ID,
+		Name:      rabbit.Name,
+		SpawnX:    rabbit.Position.X,
+		SpawnY:    rabbit.Position.Y,
+		Token:     token,
+		Room:      m.Name,
+		LastInput: rabbit.LastInput,
+	})
+}
+
+// Spectate acepta a conn como espectador: recibe el mundo completo y todo lo
+// que se difunde, pero no tiene conejo ni equipo. Puede hablar en el chat de
+// la sala.
+func (m *Match) Spectate(conn *websocket.Conn, hello *protocol.Hello, encoding protocol.Encoding) {
+	if m.MaxSpectators > 0 && m.Spectators() >= m.MaxSpectators {
+		m.turnAway(conn, encoding, fmt.Sprintf("room %s does not admit more spectators", m.Name))
+		return
+	}
+	name := cleanName(hello.Name)
+	if name == "" {
+		name = "Spectator"
+	}
+	tick := m.world.Tick
+	m.peers[conn] = &peer{
+		encoding:  encoding,
+		spectator: true,
+		name:      name,
+		messages:  newTokenBucket(m.Limits.MessageRate, m.Limits.MessageBurst, tick),
+		chats:     newTokenBucket(m.Limits.ChatRate, m.Limits.ChatBurst, tick),
+		tolerance: newTokenBucket(1, m.Limits.MaxViolations, tick),
+	}
+	log.Printf("spectator joined %s", m.Name)
+	m.Send(conn, &protocol.Welcome{
+		Version:   protocol.Version,
+		Room:      m.Name,
+		Spectator: true,
+	})
+}
+
+// turnAway despide a quien no cabe en la partida: se lo pasa a TurnAway o,
+// si no hay, lo rechaza. Lo que mande después en este tick se ignora.
+func (m *Match) turnAway(conn *websocket.Conn, encoding protocol.Encoding, reason string) {
+	if m.TurnAway == nil {
+		m.Reject(conn, reason)
+		return
+	}
+	log.Printf("turning away client: %s", reason)
+	m.rejected[conn] = struct{}{}
+	m.TurnAway(conn, encoding, reason)
+}
+
+// dropPlayerPeers echa a las conexiones que controlaban el conejo id.
+func (m *Match) dropPlayerPeers(id uuid.UUID, reason string) {
+	for conn, p := range m.peers {
+		if p.player == id {
+			delete(m.peers, conn)
+			m.server.Disconnect(conn, reason)
+		}
+	}
+}
+
+// HandlePeerEvents atiende las entradas y salidas de jugadores. Quien cierra
+// a propósito deja la partida en el acto; a quien se le corta la conexión se
+// le guarda el conejo quieto durante GracePeriod, y si no vuelve a tiempo se
+// elimina junto con su sesión.
+func (m *Match) HandlePeerEvents() {
+	for _, e := range m.server.Events() {
+		switch e.Kind {
+		case network.PeerConnected:
+			log.Printf("player %s connected", e.Player)
+		case network.PeerDisconnected:
+			m.HandleDisconnect(e)
+		}
+	}
+
+	grace := uint64(m.GracePeriod / sim.TickDuration)
+	for id, since := range m.away {
+		if m.world.Tick-since < grace {
+			continue
+		}
+		log.Printf("player %s did not come back, removing", id)
+		delete(m.away, id)
+		m.RemovePlayer(id, "timed out")
+	}
+}
+
+// Leave saca de la partida al jugador de conn como si hubiera cerrado la
+// conexión a propósito, pero sin cerrarla: la conexión vuelve al vestíbulo.
+func (m *Match) Leave(conn *websocket.Conn) {
+	p := m.peers[conn]
+	if p == nil {
+		return
+	}
+	m.HandleDisconnect(network.PeerEvent{
+		Kind:     network.PeerDisconnected,
+		Conn:     conn,
+		Player:   p.player,
+		Graceful: true,
+	})
+}
+
+// Players es cuántos jugadores tiene la partida, contando los que se han
+// desconectado y aún pueden volver.
+func (m *Match) Players() int {
+	return len(m.world.Rabbits)
+}
+
+// Spectators es cuántos espectadores tiene la partida.
+func (m *Match) Spectators() int {
+	n := 0
+	for _, p := range m.peers {
+		if p.spectator {
+			n++
+		}
+	}
+	return n
+}
+
+// HasSession dice si token permite recuperar un conejo de esta partida.
+func (m *Match) HasSession(token string) bool {
+	_, ok := m.sessions[token]
+	return ok && token != ""
+}
+
+// RemovePlayer quita de la partida el conejo de un jugador y su sesión, y
+// avisa a los demás.
+func (m *Match) RemovePlayer(id uuid.UUID, reason string) {
+	rabbit := m.world.Rabbits[id]
+	if rabbit == nil {
+		return
+	}
+	m.world.RemoveRabbit(id)
+	for token, player := range m.sessions {
+		if player == id {
+			delete(m.sessions, token)
+		}
+	}
+	m.Broadcast(&protocol.Leave{ID: id, Name: rabbit.Name, Reason: reason})
+	if reason == "left" {
+		m.Announce("%s left", rabbit.Name)
+	} else {
+		m.Announce("%s left (%s)", rabbit.Name, reason)
+	}
+}
+
+func (m *Match) HandleDisconnect(e network.PeerEvent) {
+	p, ok := m.peers[e.Conn]
+	if !ok {
+		return
+	}
+	delete(m.peers, e.Conn)
+	if p.spectator {
+		log.Printf("spectator left %s", m.Name)
+		return
+	}
+	delete(m.inputs, e.Player)
+	if m.playerConnected(e.Player) {
+		// Ya ha vuelto por otra conexión
+		return
+	}
+	if e.Graceful {
+		log.Printf("player %s left", e.Player)
+		m.RemovePlayer(e.Player, "left")
+		return
+	}
+	log.Printf("player %s disconnected", e.Player)
+	m.away[e.Player] = m.world.Tick
+	if rabbit := m.world.Rabbits[e.Player]; rabbit != nil {
+		// Sin comandos seguiría a la deriva: se le para hasta que vuelva
+		rabbit.Speed = 0
+	}
+}
+
+func (m *Match) playerConnected(id uuid.UUID) bool {
+	for _, p := range m.peers {
+		if p.player == id {
+			return true
+		}
+	}
+	return false
+}
+
+// cleanName quita del nombre lo que no se puede pintar y lo recorta.
+func cleanName(name string) string {
+	return cleanText(name, maxNameLength)
+}
+
+// cleanText quita del texto lo que no se puede pintar y lo recorta a
+// maxLength letras; con maxLength cero no lo recorta.
+func cleanText(text string, maxLength int) string {
+	text = strings.ToValidUTF8(text, "")
+	text = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, text)
+	text = strings.TrimSpace(text)
+	for maxLength > 0 && utf8.RuneCountInString(text) > maxLength {
+		_, size := utf8.DecodeLastRuneInString(text)
+		text = text[:len(text)-size]
+	}
+	return text
+}
+
+func newToken() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// Reject le dice al cliente por qué no se le acepta y lo desconecta. Si ya
+// estaba jugando, su conejo sale de la partida.
+func (m *Match) Reject(conn *websocket.Conn, reason string) {
+	log.Printf("rejecting client: %s", reason)
+	m.rejected[conn] = struct{}{}
+	m.Send(conn, &protocol.Reject{Reason: reason})
+	p := m.peers[conn]
+	delete(m.peers, conn)
+	m.server.Disconnect(conn, reason)
+	if p != nil && !p.spectator && !m.playerConnected(p.player) {
+		// Al que echamos no se le guarda el conejo
+		delete(m.inputs, p.player)
+		delete(m.away, p.player)
+		m.RemovePlayer(p.player, "kicked")
+	}
+}
+
+func (m *Match) encode(encoding protocol.Encoding, v interface{}, sequence uint32) (string, bool) {
+	message, err := protocol.EncodeAs(encoding, v, sequence, m.world.Tick)
+	if err != nil {
+		log.Println(err)
+		return "", false
+	}
+	return message, true
+}
+
+// Send envía un mensaje a un solo cliente, en su codificación.
+func (m *Match) Send(conn *websocket.Conn, v interface{}) {
+	m.sequence++
+	encoding := protocol.EncodingJSON
+	if p, ok := m.peers[conn]; ok {
+		encoding = p.encoding
+	}
+	if message, ok := m.encode(encoding, v, m.sequence); ok {
+		m.server.Send(conn, message)
+	}
+}
+
+// Broadcast envía un mensaje a todos los clientes aceptados. Se codifica una
+// vez por cada codificación en uso.
+func (m *Match) Broadcast(v interface{}) {
+	m.broadcastTo(v, nil)
+}
+
+// broadcastTo envía un mensaje a los clientes aceptados para los que to dice
+// que sí, o a todos si to es nil.
+func (m *Match) broadcastTo(v interface{}, to func(*peer) bool) {
+	m.sequence++
+	messages := make(map[protocol.Encoding]string)
+	for conn, p := range m.peers {
+		if to != nil && !to(p) {
+			continue
+		}
+		message, ok := messages[p.encoding]
+		if !ok {
+			// Un fallo ya queda en el log y se apunta vacío para no repetirlo;
+			// las conexiones con otra codificación siguen recibiendo
+			message, _ = m.encode(p.encoding, v, m.sequence)
+			messages[p.encoding] = message
+		}
+		if message == "" {
+			continue
+		}
+		m.server.Send(conn, message)
+	}
+}
+
+// QueueInput guarda un comando para el conejo del cliente que lo envía. Un
+// comando para el conejo de otro jugador se rechaza; los que llegan demasiado
+// deprisa o con una secuencia imposible cuentan como infracción.
+func (m *Match) QueueInput(conn *websocket.Conn, in sim.Input) {
+	p := m.peers[conn]
+	if p.spectator {
+		m.Violation(conn, "input from spectator")
+		return
+	}
+	if in.ID != p.player {
+		m.violations["foreign input"]++
+		m.Reject(conn, "input for another player's rabbit")
+		return
+	}
+	rabbit := m.world.Rabbits[in.ID]
+	if rabbit == nil || in.Sequence == 0 {
+		return
+	}
+	pending := m.inputs[in.ID]
+	last := rabbit.LastInput
+	if len(pending) > 0 {
+		last = pending[len(pending)-1].Sequence
+	}
+	if in.Sequence <= last {
+		// comando repetido o desordenado
+		return
+	}
+	if in.Sequence-last > m.Limits.MaxInputLead && m.Limits.MaxInputLead > 0 {
+		m.Violation(conn, "input sequence out of range")
+		return
+	}
+	if !p.inputs.allow(m.world.Tick) {
+		m.Violation(conn, "input rate exceeded")
+		return
+	}
+	if in.ViewTick > m.world.Tick {
+		// Nadie puede haber visto un tick que aún no ha pasado
+		in.ViewTick = 0
+		m.Violation(conn, "view tick out of range")
+		if _, ok := m.peers[conn]; !ok {
+			return
+		}
+	}
+	if in.Fire && !p.fires.allow(m.world.Tick) {
+		in.Fire = false
+		m.Violation(conn, "fire rate exceeded")
+		if _, ok := m.peers[conn]; !ok {
+			return
+		}
+	}
+	m.inputs[in.ID] = append(pending, in)
+}
+
+// SimulateRabbits consume los comandos pendientes. Cada comando equivale a un
+// tick del conejo, así que se aplica uno por conejo y tick: mandar más
+// comandos no acelera el conejo, solo los deja en cola. Los conejos sin
+// comando avanzan igualmente con uno vacío. A quien tiene comandos
+// atrasados, por ejemplo tras un tirón de la red, se le aplica uno de más
+// cuando su cubo de recuperación (Limits.CatchUpRate) lo permite.
+func (m *Match) SimulateRabbits() {
+	m.world.ApplyInputs(m.nextInputs(func(*peer) bool { return true }))
+	if m.Limits.CatchUpRate <= 0 {
+		return
+	}
+	if batch := m.nextInputs(func(p *peer) bool {
+		return len(m.inputs[p.player]) > 0 && p.catchUp.allow(m.world.Tick)
+	}); len(batch) > 0 {
+		m.world.CatchUp(batch)
+	}
+}
+
+// nextInputs saca el siguiente comando pendiente de cada jugador que acepta
+// take. Los comandos se graban con el tick en que se aplican, no con el que
+// llegaron.
+func (m *Match) nextInputs(take func(*peer) bool) map[uuid.UUID]sim.Input {
+	batch := make(map[uuid.UUID]sim.Input)
+	for _, p := range m.peers {
+		pending := m.inputs[p.player]
+		if p.spectator || len(pending) == 0 || !take(p) {
+			continue
+		}
+		batch[p.player] = pending[0]
+		m.inputs[p.player] = pending[1:]
+	}
+	if m.Recorder != nil {
+		for _, id := range sim.OrderedIds(batch) {
+			m.Recorder.RecordInput(m.world.Tick, batch[id])
+		}
+	}
+	return batch
+}
+
+func (m *Match) snapshotInterval() uint64 {
+	if m.SnapshotRate <= 0 || m.SnapshotRate >= sim.TickRate {
+		return 1
+	}
+	return uint64(sim.TickRate / m.SnapshotRate)
+}
+
+// Ack apunta el último snapshot que ha recibido un cliente. Los deltas
+// siguientes se calculan contra él.
+func (m *Match) Ack(conn *websocket.Conn, tick uint64) {
+	p := m.peers[conn]
+	if p == nil {
+		return
+	}
+	if tick > m.world.Tick {
+		m.Violation(conn, "snapshot ack out of range")
+		return
+	}
+	if p.hasAck && tick <= p.acked {
+		return
+	}
+	if _, ok := m.snapshots[tick]; !ok {
+		return
+	}
+	p.acked = tick
+	p.hasAck = true
+}
+
+// BroadcastSnapshot manda a cada cliente el estado del mundo como delta
+// contra el último snapshot que confirmó, o completo si acaba de entrar o su
+// confirmación es demasiado antigua. Así los que llegan tarde ven lo que ya
+// había y lo perdido se corrige en el siguiente snapshot.
+func (m *Match) BroadcastSnapshot() {
+	m.sendSnapshot(true)
+}
+
+// SyncNewPeers manda el mundo completo a los clientes que acaban de entrar
+// sin esperar al siguiente snapshot periódico.
+func (m *Match) SyncNewPeers() {
+	m.sendSnapshot(false)
+}
+
+func (m *Match) sendSnapshot(all bool) {
+	pending := false
+	for _, p := range m.peers {
+		pending = pending || all || !p.synced
+	}
+	if !pending {
+		return
+	}
+
+	snapshot := m.world.Snapshot()
+	m.snapshots[snapshot.Tick] = snapshot
+	for tick := range m.snapshots {
+		if tick+sim.SnapshotHistory < snapshot.Tick {
+			delete(m.snapshots, tick)
+		}
+	}
+
+	type key struct {
+		encoding protocol.Encoding
+		base     *sim.Snapshot
+	}
+	m.sequence++
+	messages := make(map[key]string)
+	for conn, p := range m.peers {
+		if !all && p.synced {
+			continue
+		}
+		p.synced = true
+		var base *sim.Snapshot
+		if p.hasAck {
+			base = m.snapshots[p.acked]
+		}
+		k := key{p.encoding, base}
+		message, ok := messages[k]
+		if !ok {
+			if message, ok = m.encode(p.encoding, snapshot.Diff(base), m.sequence); !ok {
+				continue
+			}
+			messages[k] = message
+		}
+		m.server.Send(conn, message)
+	}
+}
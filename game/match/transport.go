@@ -0,0 +1,27 @@
+package match
+
+import (
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+
+	"github.com/demonodojo/rabbits/game/network"
+	"github.com/demonodojo/rabbits/game/sim"
+)
+
+// Transport es por donde habla una partida con sus clientes. *network.Server
+// lo cumple tal cual; dentro de un Lobby cada sala tiene el suyo, que solo ve
+// las conexiones de la sala.
+type Transport interface {
+	ReadAll() []network.PeerMessage
+	Events() []network.PeerEvent
+	Bind(conn *websocket.Conn, player uuid.UUID)
+	Send(conn *websocket.Conn, message string)
+	Disconnect(conn *websocket.Conn, reason string)
+}
+
+// Recorder apunta los comandos que aplica la partida y el estado que
+// difunde. *replay.Recorder lo cumple.
+type Recorder interface {
+	RecordInput(tick uint64, in sim.Input)
+	RecordSnapshot(snapshot *sim.Snapshot)
+}
@@ -3,7 +3,10 @@ package network
 import (
 	"github.com/gorilla/websocket"
 	"log"
+	"sync"
 	"time"
+
+	"github.com/demonodojo/rabbits/game/protocol"
 )
 
 type GenericClient interface {
@@ -11,72 +14,251 @@ type GenericClient interface {
 	Write(message string)
 	Read() (string, bool)
 	ReadAll() []string
+	Encoding() protocol.Encoding
+	State() ConnectionState
+	// SetHandshake indica el mensaje que se manda nada más conectar, antes
+	// que cualquier otro, también tras cada reconexión.
+	SetHandshake(hello func() string)
+	// Latency devuelve el RTT y el jitter medidos con el servidor.
+	Latency() LatencyStats
 }
 
-// Client representa a un cliente conectado a un servidor WebSocket.
+// Client representa a un cliente conectado a un servidor WebSocket. Si la
+// conexión se corta vuelve a conectar solo, esperando cada vez más entre
+// intentos.
 type Client struct {
 	Conn        *websocket.Conn
 	IncomingMsg *MessageQueue
 	OutgoingMsg *MessageQueue
+	url         string
+	options     ClientOptions
+	mutex       sync.Mutex
+	state       ConnectionState
+	handshake   func() string
+	greeted     bool // ya se ha mandado el saludo en esta conexión
+	latency     Latency
 	done        chan struct{}
+	closeOnce   sync.Once
 }
 
-func NewClient(url string) (*Client, error) {
+func NewClient(url string, opts ...ClientOption) (*Client, error) {
 	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
 	if err != nil {
 		return nil, err
 	}
+	options := newClientOptions(opts)
 	client := &Client{
 		Conn:        conn,
-		IncomingMsg: NewMessageQueue(),
-		OutgoingMsg: NewMessageQueue(),
+		IncomingMsg: NewBoundedMessageQueue(options.Incoming),
+		OutgoingMsg: NewBoundedMessageQueue(options.Outbound.queue()),
+		url:         url,
+		options:     options,
+		state:       StateConnected,
 		done:        make(chan struct{}),
 	}
-	go client.readPump()
-	go client.writePump()
+	go client.run(conn)
 	return client, nil
 }
 
-func (c *Client) readPump() {
-	defer close(c.done)
+// run atiende la conexión y, cuando se corta, reconecta hasta que se llame
+// a Close.
+func (c *Client) run(conn *websocket.Conn) {
+	for {
+		if err := c.serve(conn); websocket.IsCloseError(err, websocket.ClosePolicyViolation) {
+			// Nos han echado: volver a entrar no cambiaría nada
+			log.Println("desconectado por el servidor:", err)
+			c.Close()
+			return
+		}
+
+		select {
+		case <-c.done:
+			return
+		default:
+		}
+		c.setState(StateReconnecting)
+
+		conn = c.reconnect()
+		if conn == nil {
+			return
+		}
+	}
+}
+
+// serve mueve los mensajes de conn hasta que se corta y devuelve el error de
+// lectura.
+func (c *Client) serve(conn *websocket.Conn) error {
+	stop := make(chan struct{})
+	writerDone := make(chan struct{})
+	go func() {
+		c.writePump(conn, stop)
+		close(writerDone)
+	}()
+	err := c.readPump(conn)
+	close(stop)
+	conn.Close()
+	<-writerDone
+	return err
+}
+
+func (c *Client) reconnect() *websocket.Conn {
+	for attempt := 0; ; attempt++ {
+		wait := c.options.backoff(attempt)
+		log.Printf("reconectando en %v (intento %d)", wait, attempt+1)
+		select {
+		case <-c.done:
+			return nil
+		case <-time.After(wait):
+		}
+		conn, _, err := websocket.DefaultDialer.Dial(c.url, nil)
+		if err != nil {
+			log.Println("dial:", err)
+			continue
+		}
+
+		c.mutex.Lock()
+		select {
+		case <-c.done:
+			// Se cerró mientras conectábamos
+			c.mutex.Unlock()
+			conn.Close()
+			return nil
+		default:
+		}
+		c.Conn = conn
+		c.state = StateConnected
+		c.greeted = false
+		c.mutex.Unlock()
+		// Lo que no llegó a salir era para la conexión anterior
+		c.OutgoingMsg.ReadAll()
+		return conn
+	}
+}
+
+func (c *Client) readPump(conn *websocket.Conn) error {
+	// Si el servidor deja de responder a los pings la lectura falla por
+	// plazo y se reconecta
+	watch(conn, c.options.Heartbeat, &c.latency)
 	for {
-		_, message, err := c.Conn.ReadMessage()
+		_, message, err := conn.ReadMessage()
 		if err != nil {
 			log.Println("read:", err)
-			return
+			return err
+		}
+		alive(conn, c.options.Heartbeat)
+		messages, err := unpack(message)
+		if err != nil {
+			log.Println("read:", err)
+		}
+		for _, message := range messages {
+			log.Printf("Mensaje leido: %s\n", message)
+			c.IncomingMsg.Enqueue(message)
 		}
-		log.Printf("Mensaje leido: %s\n", message)
-		c.IncomingMsg.Enqueue(string(message))
 	}
 }
 
-func (c *Client) writePump() {
-	ticker := time.NewTicker(1 * time.Millisecond)
-	defer ticker.Stop()
+// writePump duerme hasta que hay algo que enviar y entonces manda todo lo
+// pendiente de una vez.
+func (c *Client) writePump(conn *websocket.Conn, stop <-chan struct{}) {
+	timeout := c.options.Heartbeat.Timeout
+	if message, ok := c.greet(); ok {
+		if err := flush(conn, []string{message}, timeout); err != nil {
+			log.Println("write:", err)
+			return
+		}
+	}
+
+	pinger := time.NewTicker(c.options.Heartbeat.Interval)
+	defer pinger.Stop()
+	var last time.Time
 	for {
 		select {
-		case <-c.done:
+		case <-stop:
 			return
-		case <-ticker.C:
-			message, ok := c.OutgoingMsg.Dequeue()
-			if !ok {
+		case <-pinger.C:
+			if err := ping(conn, c.options.Heartbeat); err != nil {
+				log.Println("ping:", err)
+				return
+			}
+		case <-c.OutgoingMsg.Ready():
+			if !c.options.Outbound.throttle(last, stop) {
+				return
+			}
+			messages := c.OutgoingMsg.ReadAll()
+			if len(messages) == 0 {
 				continue
 			}
-			log.Printf("Escribiendo: %s\n", message)
-			if err := c.Conn.WriteMessage(websocket.TextMessage, []byte(message)); err != nil {
+			for _, message := range messages {
+				log.Printf("Escribiendo: %s\n", message)
+			}
+			if err := flush(conn, messages, timeout); err != nil {
 				log.Println("write:", err)
 				return
 			}
+			last = time.Now()
 		}
 	}
 }
 
+func (c *Client) setState(state ConnectionState) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.state != StateClosed {
+		c.state = state
+	}
+}
+
+// State devuelve el estado actual de la conexión.
+func (c *Client) State() ConnectionState {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.state
+}
+
+// greet devuelve el saludo si todavía no se ha mandado en esta conexión.
+func (c *Client) greet() (string, bool) {
+	c.mutex.Lock()
+	hello := c.handshake
+	ok := hello != nil && !c.greeted && c.state == StateConnected
+	c.greeted = c.greeted || ok
+	c.mutex.Unlock()
+	if !ok {
+		return "", false
+	}
+	return hello(), true
+}
+
+// SetHandshake guarda el saludo y, si ya estamos conectados, lo manda.
+func (c *Client) SetHandshake(hello func() string) {
+	c.mutex.Lock()
+	c.handshake = hello
+	c.mutex.Unlock()
+	if message, ok := c.greet(); ok {
+		c.OutgoingMsg.Enqueue(message)
+	}
+}
+
 func (c *Client) Close() {
-	close(c.done)
-	c.Conn.Close()
+	c.closeOnce.Do(func() {
+		c.mutex.Lock()
+		c.state = StateClosed
+		close(c.done)
+		conn := c.Conn
+		c.mutex.Unlock()
+		// Avisamos al servidor de que nos vamos a propósito
+		closeMessage := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "bye")
+		conn.WriteControl(websocket.CloseMessage, closeMessage, time.Now().Add(time.Second))
+		conn.Close()
+	})
 }
 
+// Write encola un mensaje. Mientras no hay conexión, o si la cola está
+// llena, los mensajes se descartan: la escena vuelve a saludar al
+// reconectar.
 func (c *Client) Write(message string) {
+	if c.State() != StateConnected {
+		return
+	}
 	c.OutgoingMsg.Enqueue(message)
 }
 
@@ -87,3 +269,13 @@ func (c *Client) Read() (string, bool) {
 func (c *Client) ReadAll() []string {
 	return c.IncomingMsg.ReadAll()
 }
+
+// Latency devuelve el RTT y el jitter medidos con los pings.
+func (c *Client) Latency() LatencyStats {
+	return c.latency.Stats()
+}
+
+// Encoding devuelve la codificación elegida para esta conexión.
+func (c *Client) Encoding() protocol.Encoding {
+	return c.options.Encoding
+}
@@ -3,6 +3,7 @@
 package network
 
 import (
+	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 	"log"
 	"sync"
@@ -15,28 +16,59 @@ type ClientManager struct {
 	unregister    chan *websocket.Conn      // Un canal para desregistrar conexiones existentes
 	messageEvents chan *websocket.Conn      // Cambiado para transportar solo el identificador del Peer
 
+	// Heartbeat configura los pings de cada peer. Hay que fijarlo antes de
+	// llamar a Run.
+	Heartbeat Heartbeat
+	// Outbound configura la cola de salida de cada peer. Hay que fijarlo
+	// antes de llamar a Run.
+	Outbound Outbound
+	// ReadLimit es el tamaño máximo de una trama recibida. Hay que fijarlo
+	// antes de llamar a Run.
+	ReadLimit int64
+
 	allMessages *PeerMessageQueue
+	loopbacks   []*LoopbackClient             // Clientes en memoria, por orden de llegada
+	players     map[*websocket.Conn]uuid.UUID // Jugador de cada conexión
+	events      []PeerEvent                   // Entradas y salidas desde la última lectura
 	mutex       sync.Mutex
+	done        chan struct{}
+	closeOnce   sync.Once
 }
 
 // NewClientManager crea e inicializa una nueva instancia de ClientManager.
-func NewClientManager() *ClientManager {
+// incoming configura la cola con los mensajes de todos los clientes; con
+// Block, si nadie la lee, se deja de leer de los sockets y los clientes
+// notan la presión.
+func NewClientManager(incoming QueueOptions) *ClientManager {
 	return &ClientManager{
 		peers:         make(map[*websocket.Conn]*Peer),
 		register:      make(chan *websocket.Conn),
 		unregister:    make(chan *websocket.Conn),
 		messageEvents: make(chan *websocket.Conn),
-		allMessages:   NewPeerMessageQueue(),
+		allMessages:   NewBoundedPeerMessageQueue(incoming),
+		players:       make(map[*websocket.Conn]uuid.UUID),
+		done:          make(chan struct{}),
 	}
 }
 
-// Run inicia el proceso de manejo de las conexiones de clientes.
+// Run inicia el proceso de manejo de las conexiones de clientes. Los
+// mensajes recibidos se reparten en otra goroutine: si la cola de entrada se
+// llena y bloquea, solo se dejan de leer los sockets, y las altas y bajas de
+// conexiones siguen atendiéndose.
 func (manager *ClientManager) Run() {
+	go manager.deliver()
 	for {
 		select {
+		case <-manager.done:
+			return
 		case conn := <-manager.register:
+			if manager.ReadLimit > 0 {
+				// Una trama mayor cierra la conexión con 1009 antes de
+				// leerla entera
+				conn.SetReadLimit(manager.ReadLimit)
+			}
 			manager.mutex.Lock()
-			manager.peers[conn] = NewPeer(conn, manager.messageEvents)
+			manager.peers[conn] = NewPeer(conn, manager.messageEvents, manager.unregister, manager.Heartbeat, manager.Outbound)
 			manager.mutex.Unlock()
 
 		case conn := <-manager.unregister:
@@ -44,10 +76,34 @@ func (manager *ClientManager) Run() {
 			if peer, ok := manager.peers[conn]; ok {
 				delete(manager.peers, conn)
 				peer.Close() // Asegúrate de cerrar el Peer adecuadamente
+				// Los que echamos con Disconnect ya no están en peers: solo
+				// se avisa de los que se han ido por su cuenta
+				manager.events = append(manager.events, PeerEvent{
+					Kind:     PeerDisconnected,
+					Conn:     conn,
+					Player:   manager.players[conn],
+					Graceful: peer.Graceful(),
+				})
 			}
+			delete(manager.players, conn)
 			manager.mutex.Unlock()
+		}
+	}
+}
+
+// deliver pasa a allMessages los mensajes que avisan los peers. Mientras
+// espera sitio en la cola los peers no pueden avisar de más, así que dejan
+// de leer de sus sockets.
+func (manager *ClientManager) deliver() {
+	for {
+		select {
+		case <-manager.done:
+			return
 		case peerConn := <-manager.messageEvents:
-			if peer, ok := manager.peers[peerConn]; ok {
+			manager.mutex.Lock()
+			peer, ok := manager.peers[peerConn]
+			manager.mutex.Unlock()
+			if ok {
 				// Intenta leer un mensaje de la cola de salida del Peer
 				log.Println("Aviso de mensaje entrante")
 				if message, ok := peer.Read(); ok {
@@ -64,39 +120,82 @@ func (manager *ClientManager) Run() {
 
 // RegisterClient añade una nueva conexión de cliente al ClientManager.
 func (manager *ClientManager) RegisterClient(conn *websocket.Conn) {
-	manager.register <- conn
+	select {
+	case manager.register <- conn:
+	case <-manager.done:
+		conn.Close()
+	}
 }
 
 // UnregisterClient elimina una conexión de cliente existente del ClientManager.
 func (manager *ClientManager) UnregisterClient(conn *websocket.Conn) {
-	manager.unregister <- conn
+	select {
+	case manager.unregister <- conn:
+	case <-manager.done:
+	}
 }
 
 // GetClients devuelve una lista de todas las conexiones de clientes activas.
 func (manager *ClientManager) GetClients() []*websocket.Conn {
 	manager.mutex.Lock()
 	defer manager.mutex.Unlock()
-	clients := make([]*websocket.Conn, 0, len(manager.peers))
+	clients := make([]*websocket.Conn, 0, len(manager.peers)+len(manager.loopbacks))
 	for conn := range manager.peers {
 		clients = append(clients, conn)
 	}
+	for _, client := range manager.loopbacks {
+		clients = append(clients, client.conn)
+	}
 	return clients
 }
 
+// Close cierra todas las conexiones y detiene Run.
 func (manager *ClientManager) Close() {
-
-	for conn := range manager.peers {
-		manager.UnregisterClient(conn)
-	}
+	manager.closeOnce.Do(func() {
+		close(manager.done)
+		manager.allMessages.Close()
+		manager.mutex.Lock()
+		defer manager.mutex.Unlock()
+		for conn, peer := range manager.peers {
+			delete(manager.peers, conn)
+			peer.Close()
+		}
+		for _, client := range manager.loopbacks {
+			client.down.close()
+		}
+		manager.loopbacks = nil
+	})
 }
 
 func (manager *ClientManager) Write(conn *websocket.Conn, message string) {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
 	if peer, ok := manager.peers[conn]; ok {
 		peer.Write(message)
+	} else if client := manager.loopback(conn); client != nil {
+		client.down.send(message)
+	}
+}
+
+// Disconnect cierra la conexión de un cliente explicándole el motivo.
+func (manager *ClientManager) Disconnect(conn *websocket.Conn, reason string) {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+	if peer, ok := manager.peers[conn]; ok {
+		delete(manager.peers, conn)
+		delete(manager.players, conn)
+		peer.CloseWithReason(reason)
+	} else if client := manager.loopback(conn); client != nil {
+		// Lo que ya estaba en camino, como el motivo, llega antes del cierre
+		manager.removeLoopback(conn)
+		delete(manager.players, conn)
+		client.down.close()
 	}
 }
 
 func (manager *ClientManager) Read(conn *websocket.Conn, message string) (string, bool) {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
 	if peer, ok := manager.peers[conn]; ok {
 		return peer.Read()
 	} else {
@@ -104,8 +203,69 @@ func (manager *ClientManager) Read(conn *websocket.Conn, message string) (string
 	}
 }
 
+// Latency devuelve la latencia medida con el cliente de conn.
+func (manager *ClientManager) Latency(conn *websocket.Conn) (LatencyStats, bool) {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+	if peer, ok := manager.peers[conn]; ok {
+		return peer.Latency(), true
+	}
+	if client := manager.loopback(conn); client != nil {
+		return client.Latency(), true
+	}
+	return LatencyStats{}, false
+}
+
+// Bind asocia la conexión con el jugador que controla y emite PeerConnected.
+func (manager *ClientManager) Bind(conn *websocket.Conn, player uuid.UUID) {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+	if _, ok := manager.peers[conn]; !ok && manager.loopback(conn) == nil {
+		return
+	}
+	manager.players[conn] = player
+	manager.events = append(manager.events, PeerEvent{Kind: PeerConnected, Conn: conn, Player: player})
+}
+
+// Events devuelve las entradas y salidas de clientes desde la última
+// llamada. Las conexiones cerradas con Disconnect no generan evento.
+func (manager *ClientManager) Events() []PeerEvent {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+	events := manager.events
+	manager.events = nil
+	return events
+}
+
+// Stats devuelve las métricas de la cola de mensajes recibidos.
+func (manager *ClientManager) Stats() QueueStats {
+	return manager.allMessages.Stats()
+}
+
+// PeerStats devuelve las métricas de la cola de salida del cliente de conn.
+func (manager *ClientManager) PeerStats(conn *websocket.Conn) (QueueStats, bool) {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+	if peer, ok := manager.peers[conn]; ok {
+		return peer.OutgoingMsg.Stats(), true
+	}
+	return QueueStats{}, false
+}
+
+// ReadAll devuelve lo recibido de todos los clientes, también de los que
+// están en memoria. Lo que queda de una conexión que ya se ha ido se
+// descarta: su salida ya está entre los eventos.
 func (manager *ClientManager) ReadAll() []PeerMessage {
-	return manager.allMessages.ReadAll()
+	messages := manager.allMessages.ReadAll()
+	manager.mutex.Lock()
+	kept := messages[:0]
+	for _, msg := range messages {
+		if _, ok := manager.peers[msg.Peer]; ok {
+			kept = append(kept, msg)
+		}
+	}
+	manager.mutex.Unlock()
+	return append(kept, manager.readLoopbacks()...)
 }
 
 func (manager *ClientManager) Broadcast(message string) {
@@ -115,4 +275,7 @@ func (manager *ClientManager) Broadcast(message string) {
 	for _, peer := range manager.peers {
 		peer.Write(message)
 	}
+	for _, client := range manager.loopbacks {
+		client.down.send(message)
+	}
 }
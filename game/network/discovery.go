@@ -0,0 +1,185 @@
+// network/discovery.go
+
+package network
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/demonodojo/rabbits/game/protocol"
+)
+
+const (
+	// DefaultDiscoveryPort es el puerto UDP en el que los servidores
+	// responden a las sondas de descubrimiento de la red local.
+	DefaultDiscoveryPort = 8080
+	DefaultDiscoveryAddr = ":8080"
+
+	// DefaultDiscoveryTimeout es cuánto espera Discover las respuestas.
+	DefaultDiscoveryTimeout = time.Second
+
+	maxDatagramSize = 8 << 10
+)
+
+var (
+	discoveryProbe = []byte("rabbits?1")
+	discoveryReply = []byte("rabbits!1")
+)
+
+// Announcement es lo que un servidor responde a una sonda de descubrimiento.
+type Announcement struct {
+	ID      string              `json:"id"`
+	Name    string              `json:"name"`
+	Port    int                 `json:"port"` // Puerto TCP del WebSocket
+	Path    string              `json:"path"`
+	Players int                 `json:"players"`
+	Rooms   []protocol.RoomInfo `json:"rooms,omitempty"`
+}
+
+// DiscoveredServer es un servidor encontrado por Discover.
+type DiscoveredServer struct {
+	Announcement
+	URL  string        // Dirección WebSocket a la que conectar
+	Ping time.Duration // Tiempo entre la sonda y la respuesta
+}
+
+// serveDiscovery responde a las sondas que lleguen a conn hasta que se
+// cierre.
+func (s *Server) serveDiscovery(conn net.PacketConn) {
+	buf := make([]byte, maxDatagramSize)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		if !bytes.Equal(buf[:n], discoveryProbe) {
+			continue
+		}
+		reply, err := json.Marshal(s.announcement())
+		if err != nil {
+			log.Println("discovery:", err)
+			continue
+		}
+		conn.WriteTo(append(append([]byte{}, discoveryReply...), reply...), addr)
+	}
+}
+
+func (s *Server) announcement() Announcement {
+	s.announceMutex.Lock()
+	defer s.announceMutex.Unlock()
+	a := Announcement{
+		ID:    s.id,
+		Name:  s.Name,
+		Path:  s.Path,
+		Rooms: s.rooms,
+	}
+	if a.Name == "" {
+		a.Name, _ = os.Hostname()
+	}
+	if addr, ok := s.Addr().(*net.TCPAddr); ok {
+		a.Port = addr.Port
+	} else if _, port, err := net.SplitHostPort(s.Port); err == nil {
+		a.Port, _ = strconv.Atoi(port)
+	}
+	for _, room := range s.rooms {
+		a.Players += room.Players
+	}
+	return a
+}
+
+// Discover busca servidores en la red local mandando una sonda a port por
+// loopback y por broadcast en cada interfaz, y recoge las respuestas que
+// lleguen antes de timeout.
+func Discover(port int, timeout time.Duration) ([]DiscoveredServer, error) {
+	conn, err := net.ListenPacket("udp4", ":0")
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	sent := time.Now()
+	probed := 0
+	for _, ip := range broadcastAddresses() {
+		addr := &net.UDPAddr{IP: ip, Port: port}
+		if _, err := conn.WriteTo(discoveryProbe, addr); err != nil {
+			log.Printf("discovery %s: %v", addr, err)
+			continue
+		}
+		probed++
+	}
+	if probed == 0 {
+		return nil, fmt.Errorf("network: could not send any discovery probe")
+	}
+
+	var servers []DiscoveredServer
+	seen := make(map[string]bool)
+	buf := make([]byte, maxDatagramSize)
+	conn.SetReadDeadline(sent.Add(timeout))
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				return servers, nil
+			}
+			return servers, err
+		}
+		if !bytes.HasPrefix(buf[:n], discoveryReply) {
+			continue
+		}
+		var found DiscoveredServer
+		if err := json.Unmarshal(buf[len(discoveryReply):n], &found.Announcement); err != nil {
+			continue
+		}
+		// Un servidor contesta una vez por cada sonda que le llega.
+		if seen[found.ID] {
+			continue
+		}
+		seen[found.ID] = true
+		host := addr.(*net.UDPAddr).IP.String()
+		found.URL = fmt.Sprintf("ws://%s%s", net.JoinHostPort(host, strconv.Itoa(found.Port)), found.Path)
+		found.Ping = time.Since(sent)
+		servers = append(servers, found)
+	}
+}
+
+// broadcastAddresses devuelve loopback, el broadcast general y el de cada
+// red IPv4 de las interfaces activas.
+func broadcastAddresses() []net.IP {
+	ips := []net.IP{net.IPv4(127, 0, 0, 1), net.IPv4bcast}
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		return ips
+	}
+	for _, iface := range interfaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagBroadcast == 0 {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, a := range addrs {
+			ipnet, ok := a.(*net.IPNet)
+			if !ok || ipnet.IP.To4() == nil {
+				continue
+			}
+			ip := ipnet.IP.To4()
+			mask := ipnet.Mask
+			if len(mask) == net.IPv6len {
+				mask = mask[12:]
+			}
+			broadcast := make(net.IP, net.IPv4len)
+			for i := range ip {
+				broadcast[i] = ip[i] | ^mask[i]
+			}
+			ips = append(ips, broadcast)
+		}
+	}
+	return ips
+}
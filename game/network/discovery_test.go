@@ -0,0 +1,57 @@
+package network_test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/demonodojo/rabbits/game/network"
+	"github.com/demonodojo/rabbits/game/protocol"
+)
+
+// Discover encuentra por loopback a un servidor que solo escucha en
+// 127.0.0.1, con su nombre, sus salas y el puerto de su WebSocket.
+func TestDiscoverOnLoopback(t *testing.T) {
+	server := network.NewServer("127.0.0.1:0")
+	server.Name = "test"
+	server.Discovery = "127.0.0.1:0"
+	if err := server.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer server.Shutdown(context.Background())
+	server.SetRooms([]protocol.RoomInfo{{Name: "main", Players: 2, MaxPlayers: 16}})
+
+	udp, ok := server.DiscoveryAddr().(*net.UDPAddr)
+	if !ok {
+		t.Fatalf("discovery is not listening: %v", server.DiscoveryAddr())
+	}
+	servers, err := network.Discover(udp.Port, 500*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(servers) != 1 {
+		t.Fatalf("found %d servers, want 1: %+v", len(servers), servers)
+	}
+
+	found := servers[0]
+	tcp := server.Addr().(*net.TCPAddr)
+	if found.Name != "test" || found.Port != tcp.Port || found.Players != 2 || len(found.Rooms) != 1 {
+		t.Errorf("announcement %+v does not match the server on port %d", found.Announcement, tcp.Port)
+	}
+	if found.URL == "" {
+		t.Error("discovered server has no URL")
+	}
+}
+
+// Sin Discovery el servidor no abre ningún puerto UDP.
+func TestDiscoveryOffByDefault(t *testing.T) {
+	server := network.NewServer("127.0.0.1:0")
+	if err := server.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer server.Shutdown(context.Background())
+	if addr := server.DiscoveryAddr(); addr != nil {
+		t.Errorf("discovery listening on %v without Server.Discovery", addr)
+	}
+}
@@ -0,0 +1,28 @@
+package network
+
+import (
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+type PeerEventKind int
+
+const (
+	// PeerConnected se emite cuando una conexión queda asociada a un
+	// jugador con Bind.
+	PeerConnected PeerEventKind = iota
+	// PeerDisconnected se emite cuando una conexión se corta sin que la
+	// hayamos echado con Disconnect.
+	PeerDisconnected
+)
+
+// PeerEvent cuenta que un cliente ha entrado o se ha ido. Player es el
+// jugador asociado con Bind, o uuid.Nil si no llegó a asociarse.
+type PeerEvent struct {
+	Kind   PeerEventKind
+	Conn   *websocket.Conn
+	Player uuid.UUID
+	// Graceful es true si el cliente cerró a propósito; si no, se ha
+	// perdido y puede que vuelva.
+	Graceful bool
+}
@@ -0,0 +1,125 @@
+package network
+
+import (
+	"encoding/binary"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/demonodojo/rabbits/game/protocol"
+)
+
+const (
+	// batchMagic abre una trama binaria con varios sobres binarios dentro,
+	// cada uno precedido de su longitud.
+	batchMagic = 0xB8
+
+	// pingMagic y pongMagic abren los pings de aplicación. El navegador no
+	// puede mandar tramas de ping de websocket, así que JSClient manda una
+	// trama binaria con pingMagic y la hora, y el Peer la devuelve con
+	// pongMagic delante.
+	pingMagic = 0xB9
+	pongMagic = 0xBA
+
+	// maxFrameSize es el tamaño a partir del cual un lote se parte en varias
+	// tramas.
+	maxFrameSize = 32 << 10
+)
+
+var errBadBatch = errors.New("network: malformed batch frame")
+
+// frame es una trama websocket lista para enviar. Los sobres binarios van en
+// tramas binarias y el resto en tramas de texto, así JSON sigue siendo
+// legible en las herramientas del navegador.
+type frame struct {
+	data   []byte
+	binary bool
+}
+
+func (f frame) messageType() int {
+	if f.binary {
+		return websocket.BinaryMessage
+	}
+	return websocket.TextMessage
+}
+
+// pack junta los mensajes en las menos tramas posibles. Los JSON van
+// separados por saltos de línea, que json.Marshal nunca produce, y los
+// binarios tras batchMagic con su longitud delante. Un mensaje solo viaja
+// tal cual, así que un lote de uno es igual que un mensaje suelto.
+func pack(messages []string) []frame {
+	var frames []frame
+	for start := 0; start < len(messages); {
+		isBinary := protocol.IsBinary(messages[start])
+		size := len(messages[start])
+		end := start + 1
+		for end < len(messages) && protocol.IsBinary(messages[end]) == isBinary &&
+			size+len(messages[end]) <= maxFrameSize {
+			size += len(messages[end])
+			end++
+		}
+		frames = append(frames, packGroup(messages[start:end], isBinary))
+		start = end
+	}
+	return frames
+}
+
+func packGroup(messages []string, isBinary bool) frame {
+	if len(messages) == 1 {
+		return frame{data: []byte(messages[0]), binary: isBinary}
+	}
+	if !isBinary {
+		return frame{data: []byte(strings.Join(messages, "\n"))}
+	}
+	data := []byte{batchMagic}
+	for _, message := range messages {
+		data = binary.AppendUvarint(data, uint64(len(message)))
+		data = append(data, message...)
+	}
+	return frame{data: data, binary: true}
+}
+
+// unpack separa los mensajes de una trama recibida.
+func unpack(data []byte) ([]string, error) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+	if data[0] != batchMagic {
+		if protocol.IsBinary(string(data)) {
+			return []string{string(data)}, nil
+		}
+		var messages []string
+		for _, line := range strings.Split(string(data), "\n") {
+			if line != "" {
+				messages = append(messages, line)
+			}
+		}
+		return messages, nil
+	}
+
+	var messages []string
+	for rest := data[1:]; len(rest) > 0; {
+		n, read := binary.Uvarint(rest)
+		if read <= 0 || n > uint64(len(rest)-read) {
+			return messages, errBadBatch
+		}
+		rest = rest[read:]
+		messages = append(messages, string(rest[:n]))
+		rest = rest[n:]
+	}
+	return messages, nil
+}
+
+// flush envía los mensajes en lotes. El plazo de escritura evita que un
+// cliente que no lee bloquee al escritor para siempre.
+func flush(conn *websocket.Conn, messages []string, timeout time.Duration) error {
+	for _, f := range pack(messages) {
+		conn.SetWriteDeadline(time.Now().Add(timeout))
+		if err := conn.WriteMessage(f.messageType(), f.data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package network
+
+import (
+	"encoding/binary"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	DefaultPingInterval = 5 * time.Second
+	DefaultPongTimeout  = 15 * time.Second
+)
+
+// Heartbeat configura los pings que mantienen viva una conexión. Si en
+// Timeout no llega nada del otro extremo, ni mensajes ni pongs, la conexión
+// se da por muerta y se cierra.
+type Heartbeat struct {
+	Interval time.Duration
+	Timeout  time.Duration
+}
+
+// withDefaults rellena los valores que falten.
+func (h Heartbeat) withDefaults() Heartbeat {
+	if h.Interval <= 0 {
+		h.Interval = DefaultPingInterval
+	}
+	if h.Timeout <= 0 {
+		h.Timeout = DefaultPongTimeout
+	}
+	return h
+}
+
+// LatencyStats es la latencia medida con los pings: RTT suavizado y su
+// variación media, como hace TCP.
+type LatencyStats struct {
+	RTT     time.Duration
+	Jitter  time.Duration
+	Samples int
+}
+
+// Latency acumula las medidas de RTT de una conexión.
+type Latency struct {
+	mutex sync.Mutex
+	stats LatencyStats
+}
+
+// Add incorpora una medida nueva.
+func (l *Latency) Add(sample time.Duration) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.stats.Samples == 0 {
+		l.stats.RTT = sample
+		l.stats.Jitter = sample / 2
+	} else {
+		diff := sample - l.stats.RTT
+		if diff < 0 {
+			diff = -diff
+		}
+		l.stats.Jitter += (diff - l.stats.Jitter) / 4
+		l.stats.RTT += (sample - l.stats.RTT) / 8
+	}
+	l.stats.Samples++
+}
+
+func (l *Latency) Stats() LatencyStats {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.stats
+}
+
+// pingPayload lleva la hora de envío, para medir el RTT cuando vuelve el pong.
+func pingPayload(now time.Time) []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(now.UnixNano()))
+}
+
+// watch prepara conn para que cada pong cuente como medida de latencia y
+// cualquier cosa recibida aplace el plazo de lectura.
+func watch(conn *websocket.Conn, heartbeat Heartbeat, latency *Latency) {
+	conn.SetReadDeadline(time.Now().Add(heartbeat.Timeout))
+	conn.SetPongHandler(func(data string) error {
+		now := time.Now()
+		if len(data) == 8 {
+			sent := int64(binary.BigEndian.Uint64([]byte(data)))
+			latency.Add(now.Sub(time.Unix(0, sent)))
+		}
+		return conn.SetReadDeadline(now.Add(heartbeat.Timeout))
+	})
+}
+
+// alive aplaza el plazo de lectura tras recibir un mensaje.
+func alive(conn *websocket.Conn, heartbeat Heartbeat) {
+	conn.SetReadDeadline(time.Now().Add(heartbeat.Timeout))
+}
+
+func ping(conn *websocket.Conn, heartbeat Heartbeat) error {
+	now := time.Now()
+	return conn.WriteControl(websocket.PingMessage, pingPayload(now), now.Add(heartbeat.Timeout))
+}
+
+// appPing es un ping de aplicación con la hora de envío.
+func appPing(now time.Time) []byte {
+	return append([]byte{pingMagic}, pingPayload(now)...)
+}
+
+// appPong devuelve la respuesta a data si es un ping de aplicación.
+func appPong(data []byte) ([]byte, bool) {
+	if len(data) != 9 || data[0] != pingMagic {
+		return nil, false
+	}
+	return append([]byte{pongMagic}, data[1:]...), true
+}
+
+// AddPong cuenta data como medida de latencia si es la respuesta a un ping
+// de aplicación, y dice si lo era.
+func (l *Latency) AddPong(data []byte, now time.Time) bool {
+	if len(data) != 9 || data[0] != pongMagic {
+		return false
+	}
+	sent := int64(binary.BigEndian.Uint64(data[1:]))
+	l.Add(now.Sub(time.Unix(0, sent)))
+	return true
+}
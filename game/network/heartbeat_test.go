@@ -0,0 +1,46 @@
+package network
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// El navegador no puede mandar pings de websocket: el servidor responde a
+// los de aplicación y la respuesta cuenta como medida de latencia.
+func TestPeerAnswersAppPings(t *testing.T) {
+	server := NewServer("")
+	ts := httptest.NewServer(server.Handler())
+	defer ts.Close()
+	defer server.Shutdown(context.Background())
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+DefaultPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if err := conn.WriteMessage(websocket.BinaryMessage, appPing(time.Now())); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var latency Latency
+	if !latency.AddPong(data, time.Now()) {
+		t.Fatalf("got % x, want a pong", data)
+	}
+	if stats := latency.Stats(); stats.Samples != 1 || stats.RTT <= 0 {
+		t.Errorf("latency after one pong = %+v", stats)
+	}
+	// El ping no llega al juego como mensaje
+	if messages := server.ReadAll(); len(messages) != 0 {
+		t.Errorf("server read %d messages from a ping", len(messages))
+	}
+}
@@ -4,102 +4,260 @@ package network
 
 import (
 	"context"
-	"fmt"
 	"log"
 	"nhooyr.io/websocket"
-	"syscall/js"
+	"sync"
+	"time"
+
+	"github.com/demonodojo/rabbits/game/protocol"
 )
 
-// JSClient representa a un cliente conectado a un servidor WebSocket utilizando syscall/js.
+// JSClient representa a un cliente conectado a un servidor WebSocket desde el
+// navegador. Igual que Client, vuelve a conectar solo si se corta.
 type JSClient struct {
 	Ws          *websocket.Conn
 	IncomingMsg *MessageQueue
 	OutgoingMsg *MessageQueue
-	connected   bool
-	done        chan struct{}
-	newMessage  chan struct{}
+	url         string
+	options     ClientOptions
+	mutex       sync.Mutex
+	state       ConnectionState
+	handshake   func() string
+	greeted     bool // ya se ha mandado el saludo en esta conexión
+	latency     Latency
+	ctx         context.Context
+	cancel      context.CancelFunc
+	closeOnce   sync.Once
 }
 
-func NewJSClient(url string) (*JSClient, error) {
+func NewJSClient(url string, opts ...ClientOption) (*JSClient, error) {
+	options := newClientOptions(opts)
+	ctx, cancel := context.WithCancel(context.Background())
 	client := &JSClient{
-		IncomingMsg: NewMessageQueue(),
-		OutgoingMsg: NewMessageQueue(),
-		connected:   true,
-		done:        make(chan struct{}),
-		newMessage:  make(chan struct{}, 1), // No bloqueante
+		IncomingMsg: NewBoundedMessageQueue(options.Incoming),
+		OutgoingMsg: NewBoundedMessageQueue(options.Outbound.queue()),
+		url:         url,
+		options:     options,
+		state:       StateConnected,
+		ctx:         ctx,
+		cancel:      cancel,
 	}
-	c, _, err := websocket.Dial(context.Background(), url, nil)
+	c, _, err := websocket.Dial(ctx, url, nil)
 	if err != nil {
-		fmt.Println(err, "ERROR")
+		cancel()
+		return nil, err
 	}
 	client.Ws = c
 
-	go client.writePump()
-	go client.readPump()
+	go client.run(c)
 	return client, nil
 }
 
-// onOpen se dispara cuando la conexión WebSocket está abierta y lista para enviar mensajes.
-func (c *JSClient) onOpen(this js.Value, args []js.Value) interface{} {
-	c.connected = true
-	println("WebSocket connection is open.")
-	return nil
+// run atiende la conexión y, cuando se corta, reconecta hasta que se llame
+// a Close.
+func (c *JSClient) run(ws *websocket.Conn) {
+	for {
+		if err := c.serve(ws); websocket.CloseStatus(err) == websocket.StatusPolicyViolation {
+			// Nos han echado: volver a entrar no cambiaría nada
+			log.Println("desconectado por el servidor:", err)
+			c.Close()
+			return
+		}
+
+		if c.ctx.Err() != nil {
+			return
+		}
+		c.setState(StateReconnecting)
+
+		ws = c.reconnect()
+		if ws == nil {
+			return
+		}
+	}
 }
 
-func (c *JSClient) readPump() {
-	defer func() {
-		// Close the WebSocket connection when the function returns.
-		//c.Ws.Close(websocket.StatusGoingAway, "BYE")
+// serve mueve los mensajes de ws hasta que se corta y devuelve el error de
+// lectura.
+func (c *JSClient) serve(ws *websocket.Conn) error {
+	ctx, stop := context.WithCancel(c.ctx)
+	writerDone := make(chan struct{})
+	go func() {
+		c.writePump(ctx, ws)
+		close(writerDone)
 	}()
+	err := c.readPump(ctx, ws)
+	stop()
+	ws.Close(websocket.StatusGoingAway, "BYE")
+	<-writerDone
+	return err
+}
 
-	for {
-		// Read a message from the WebSocket connection.
-		messageType, payload, err := c.Ws.Read(context.Background())
+func (c *JSClient) reconnect() *websocket.Conn {
+	for attempt := 0; ; attempt++ {
+		wait := c.options.backoff(attempt)
+		log.Printf("reconectando en %v (intento %d)", wait, attempt+1)
+		select {
+		case <-c.ctx.Done():
+			return nil
+		case <-time.After(wait):
+		}
+		ws, _, err := websocket.Dial(c.ctx, c.url, nil)
+		if err != nil {
+			log.Println("dial:", err)
+			continue
+		}
+
+		c.mutex.Lock()
+		if c.ctx.Err() != nil {
+			c.mutex.Unlock()
+			ws.Close(websocket.StatusGoingAway, "BYE")
+			return nil
+		}
+		c.Ws = ws
+		c.state = StateConnected
+		c.greeted = false
+		c.mutex.Unlock()
+		// Lo que no llegó a salir era para la conexión anterior
+		c.OutgoingMsg.ReadAll()
+		return ws
+	}
+}
 
+func (c *JSClient) readPump(ctx context.Context, ws *websocket.Conn) error {
+	for {
+		// Si el servidor deja de responder a los pings la lectura falla por
+		// plazo y se reconecta
+		readCtx, cancel := context.WithTimeout(ctx, c.options.Heartbeat.Timeout)
+		_, payload, err := ws.Read(readCtx)
+		cancel()
+		if err != nil {
+			log.Println("read:", err)
+			return err
+		}
+		if c.latency.AddPong(payload, time.Now()) {
+			continue
+		}
+		messages, err := unpack(payload)
 		if err != nil {
-			// Log and panic if there is an error reading the message.
-			log.Panicf(err.Error())
+			log.Println("read:", err)
 		}
-		c.IncomingMsg.Enqueue(string(payload))
+		for _, message := range messages {
+			c.IncomingMsg.Enqueue(message)
+		}
+	}
+}
 
-		// Log the message type and payload for debugging.
-		log.Println("MessageType: ", messageType)
-		log.Println("Payload: ", string(payload))
+// flush envía los mensajes en lotes, como el cliente nativo.
+func (c *JSClient) flush(ctx context.Context, ws *websocket.Conn, messages []string) error {
+	for _, f := range pack(messages) {
+		messageType := websocket.MessageText
+		if f.binary {
+			messageType = websocket.MessageBinary
+		}
+		if err := ws.Write(ctx, messageType, f.data); err != nil {
+			return err
+		}
 	}
+	return nil
 }
 
-func (c *JSClient) writePump() {
+func (c *JSClient) writePump(ctx context.Context, ws *websocket.Conn) {
+	if message, ok := c.greet(); ok {
+		if err := c.flush(ctx, ws, []string{message}); err != nil {
+			log.Println("Error writing to WebSocket:", err)
+			return
+		}
+	}
+
+	// El navegador no manda pings de websocket: se mandan como mensajes
+	pinger := time.NewTicker(c.options.Heartbeat.Interval)
+	defer pinger.Stop()
+	var last time.Time
 	for {
 		select {
-		case <-c.done:
+		case <-ctx.Done():
 			return
-		case <-c.newMessage:
-			if c.connected {
-				message, ok := c.OutgoingMsg.Dequeue()
-				if !ok {
-					continue
-				}
-				err := c.Ws.Write(context.Background(), websocket.MessageText, []byte(message))
-				if err != nil {
-					log.Println("Error writing to WebSocket:", err)
-				}
+		case <-pinger.C:
+			if err := ws.Write(ctx, websocket.MessageBinary, appPing(time.Now())); err != nil {
+				log.Println("ping:", err)
+				return
 			}
+			continue
+		case <-c.OutgoingMsg.Ready():
 		}
+		if !c.options.Outbound.throttle(last, ctx.Done()) {
+			return
+		}
+		// Un aviso puede cubrir varios mensajes: se manda todo lo pendiente
+		messages := c.OutgoingMsg.ReadAll()
+		if len(messages) == 0 {
+			continue
+		}
+		if err := c.flush(ctx, ws, messages); err != nil {
+			log.Println("Error writing to WebSocket:", err)
+			return
+		}
+		last = time.Now()
+	}
+}
+
+func (c *JSClient) setState(state ConnectionState) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.state != StateClosed {
+		c.state = state
+	}
+}
+
+// State devuelve el estado actual de la conexión.
+func (c *JSClient) State() ConnectionState {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.state
+}
+
+// greet devuelve el saludo si todavía no se ha mandado en esta conexión.
+func (c *JSClient) greet() (string, bool) {
+	c.mutex.Lock()
+	hello := c.handshake
+	ok := hello != nil && !c.greeted && c.state == StateConnected
+	c.greeted = c.greeted || ok
+	c.mutex.Unlock()
+	if !ok {
+		return "", false
+	}
+	return hello(), true
+}
+
+// SetHandshake guarda el saludo y, si ya estamos conectados, lo manda.
+func (c *JSClient) SetHandshake(hello func() string) {
+	c.mutex.Lock()
+	c.handshake = hello
+	c.mutex.Unlock()
+	if message, ok := c.greet(); ok {
+		c.Write(message)
 	}
 }
 
 func (c *JSClient) Close() {
-	close(c.done)
-	c.Ws.Close(websocket.StatusGoingAway, "BYE")
+	c.closeOnce.Do(func() {
+		c.mutex.Lock()
+		c.state = StateClosed
+		ws := c.Ws
+		c.mutex.Unlock()
+		c.cancel()
+		ws.Close(websocket.StatusGoingAway, "BYE")
+	})
 }
 
+// Write encola un mensaje. Mientras no hay conexión, o si la cola está
+// llena, los mensajes se descartan: la escena vuelve a saludar al
+// reconectar.
 func (c *JSClient) Write(message string) {
-	println("writing message")
-	c.OutgoingMsg.Enqueue(message)
-	select {
-	case c.newMessage <- struct{}{}:
-	default:
+	if c.State() != StateConnected {
+		return
 	}
+	c.OutgoingMsg.Enqueue(message)
 }
 
 func (c *JSClient) Read() (string, bool) {
@@ -109,3 +267,13 @@ func (c *JSClient) Read() (string, bool) {
 func (c *JSClient) ReadAll() []string {
 	return c.IncomingMsg.ReadAll()
 }
+
+// Latency devuelve el RTT y el jitter medidos con los pings de aplicación.
+func (c *JSClient) Latency() LatencyStats {
+	return c.latency.Stats()
+}
+
+// Encoding devuelve la codificación elegida para esta conexión.
+func (c *JSClient) Encoding() protocol.Encoding {
+	return c.options.Encoding
+}
@@ -0,0 +1,311 @@
+// network/loopback.go
+
+package network
+
+import (
+	"log"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/demonodojo/rabbits/game/protocol"
+)
+
+// Link simula las condiciones de red de una conexión en memoria. Se aplican
+// por separado en cada sentido.
+type Link struct {
+	// Latency es lo que tarda un mensaje en llegar, en un sentido.
+	Latency time.Duration
+	// Jitter es cuánto puede variar Latency, arriba o abajo, al azar.
+	Jitter time.Duration
+	// Loss es la probabilidad, de 0 a 1, de que un mensaje se pierda.
+	Loss float64
+	// Reorder es la probabilidad, de 0 a 1, de que un mensaje se retrase lo
+	// bastante para que lo adelanten los siguientes. El resto llega en
+	// orden, como por un websocket.
+	Reorder float64
+
+	// Seed es la semilla del azar del enlace. Con la misma semilla y los
+	// mismos mensajes a las mismas horas, se pierden y se retrasan los
+	// mismos.
+	Seed int64
+	// Clock da la hora con la que se decide qué mensajes han llegado. Si es
+	// nil se usa time.Now; con un reloj propio las pruebas no dependen del
+	// reloj de la máquina.
+	Clock func() time.Time
+}
+
+// delivery es un mensaje en camino.
+type delivery struct {
+	at      time.Time
+	message string
+	close   bool // el otro extremo ha cerrado
+}
+
+// pipe lleva los mensajes de un sentido de una conexión en memoria. No usa
+// goroutines ni temporizadores: quien lee se lleva lo que ya ha llegado.
+type pipe struct {
+	mutex   sync.Mutex
+	link    Link
+	rng     *rand.Rand
+	pending []delivery // por orden de llegada
+	last    time.Time  // llegada del último mensaje que va en orden
+	closed  bool
+}
+
+func newPipe(link Link, seed int64) *pipe {
+	return &pipe{link: link, rng: rand.New(rand.NewSource(seed))}
+}
+
+func (p *pipe) now() time.Time {
+	if p.link.Clock != nil {
+		return p.link.Clock()
+	}
+	return time.Now()
+}
+
+// send pone un mensaje en camino, salvo que se pierda.
+func (p *pipe) send(message string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.closed {
+		return
+	}
+	if p.link.Loss > 0 && p.rng.Float64() < p.link.Loss {
+		return
+	}
+
+	at := p.now().Add(p.link.Latency)
+	if p.link.Jitter > 0 {
+		at = at.Add(time.Duration(p.rng.Int63n(2*int64(p.link.Jitter)+1)) - p.link.Jitter)
+	}
+	if p.link.Reorder > 0 && p.rng.Float64() < p.link.Reorder {
+		// Se queda atrás sin retrasar a los que vienen detrás
+		extra := int64(p.link.Latency+p.link.Jitter) + int64(time.Millisecond)
+		at = at.Add(time.Millisecond + time.Duration(p.rng.Int63n(extra)))
+	} else {
+		if at.Before(p.last) {
+			at = p.last
+		}
+		p.last = at
+	}
+	p.insert(delivery{at: at, message: message})
+}
+
+// close avisa al otro extremo de que se ha cerrado, detrás de todo lo que ya
+// estaba en camino.
+func (p *pipe) close() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.closed {
+		return
+	}
+	p.closed = true
+	at := p.now().Add(p.link.Latency)
+	if n := len(p.pending); n > 0 && at.Before(p.pending[n-1].at) {
+		at = p.pending[n-1].at
+	}
+	p.insert(delivery{at: at, close: true})
+}
+
+// insert coloca d por su hora de llegada, detrás de los que llegan a la vez.
+func (p *pipe) insert(d delivery) {
+	i := sort.Search(len(p.pending), func(i int) bool { return d.at.Before(p.pending[i].at) })
+	p.pending = append(p.pending, delivery{})
+	copy(p.pending[i+1:], p.pending[i:])
+	p.pending[i] = d
+}
+
+// receive devuelve los mensajes que ya han llegado y si con ellos ha llegado
+// el cierre. Tras el cierre no llega nada más.
+func (p *pipe) receive() ([]string, bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	now := p.now()
+	var messages []string
+	n := 0
+	for ; n < len(p.pending) && !p.pending[n].at.After(now); n++ {
+		if p.pending[n].close {
+			p.pending = nil
+			return messages, true
+		}
+		messages = append(messages, p.pending[n].message)
+	}
+	p.pending = p.pending[n:]
+	return messages, false
+}
+
+// LoopbackClient es un cliente conectado en memoria a un Server del mismo
+// proceso, sin sockets. Cumple GenericClient, así que ClientScene no nota la
+// diferencia, y el servidor lo trata como a cualquier otra conexión. Las
+// condiciones de red se simulan según ClientOptions.Link. Un cliente en
+// memoria no se reconecta: si el servidor lo echa, queda cerrado.
+type LoopbackClient struct {
+	conn     *websocket.Conn // solo identifica la conexión en el servidor
+	up       *pipe           // del cliente al servidor
+	down     *pipe           // del servidor al cliente
+	incoming *MessageQueue
+	options  ClientOptions
+
+	mutex sync.Mutex
+	state ConnectionState
+}
+
+func newLoopbackClient(opts []ClientOption) *LoopbackClient {
+	options := newClientOptions(opts)
+	return &LoopbackClient{
+		conn:     new(websocket.Conn),
+		up:       newPipe(options.Link, options.Link.Seed),
+		down:     newPipe(options.Link, options.Link.Seed+1),
+		incoming: NewBoundedMessageQueue(options.Incoming),
+		options:  options,
+		state:    StateConnected,
+	}
+}
+
+// poll recoge lo que ha llegado del servidor.
+func (c *LoopbackClient) poll() {
+	messages, closed := c.down.receive()
+	for _, message := range messages {
+		c.incoming.Enqueue(message)
+	}
+	if closed {
+		c.mutex.Lock()
+		c.state = StateClosed
+		c.mutex.Unlock()
+	}
+}
+
+// SetHandshake manda el saludo en el acto: la conexión ya está abierta.
+func (c *LoopbackClient) SetHandshake(hello func() string) {
+	if c.State() != StateConnected {
+		return
+	}
+	if message := hello(); message != "" {
+		c.up.send(message)
+	}
+}
+
+func (c *LoopbackClient) Write(message string) {
+	if c.State() != StateConnected {
+		return
+	}
+	c.up.send(message)
+}
+
+func (c *LoopbackClient) Read() (string, bool) {
+	c.poll()
+	return c.incoming.Dequeue()
+}
+
+func (c *LoopbackClient) ReadAll() []string {
+	c.poll()
+	return c.incoming.ReadAll()
+}
+
+func (c *LoopbackClient) State() ConnectionState {
+	c.poll()
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.state
+}
+
+// Close cierra la conexión a propósito: el servidor lo ve como una salida
+// normal cuando le llega, detrás de lo que ya estaba en camino.
+func (c *LoopbackClient) Close() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.state == StateClosed {
+		return
+	}
+	c.state = StateClosed
+	c.up.close()
+}
+
+// Latency devuelve la latencia configurada en el enlace: no hay pings que
+// medir.
+func (c *LoopbackClient) Latency() LatencyStats {
+	return LatencyStats{
+		RTT:     2 * c.options.Link.Latency,
+		Jitter:  c.options.Link.Jitter,
+		Samples: 1,
+	}
+}
+
+func (c *LoopbackClient) Encoding() protocol.Encoding {
+	return c.options.Encoding
+}
+
+// Loopback conecta al servidor un cliente en memoria. El servidor no tiene
+// que estar escuchando: con uno creado con NewServer y sin Start, cliente y
+// servidor viven en el mismo proceso sin tocar la red.
+func (s *Server) Loopback(opts ...ClientOption) *LoopbackClient {
+	s.init()
+	return s.clientManager.Loopback(opts...)
+}
+
+// Loopback registra un cliente en memoria.
+func (manager *ClientManager) Loopback(opts ...ClientOption) *LoopbackClient {
+	client := newLoopbackClient(opts)
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+	select {
+	case <-manager.done:
+		client.state = StateClosed
+		return client
+	default:
+	}
+	log.Println("Nueva Conexión en memoria")
+	manager.loopbacks = append(manager.loopbacks, client)
+	return client
+}
+
+// loopback devuelve el cliente en memoria de conn, si lo es. Hay que tener
+// el mutex.
+func (manager *ClientManager) loopback(conn *websocket.Conn) *LoopbackClient {
+	for _, client := range manager.loopbacks {
+		if client.conn == conn {
+			return client
+		}
+	}
+	return nil
+}
+
+// removeLoopback olvida el cliente en memoria de conn. Hay que tener el
+// mutex.
+func (manager *ClientManager) removeLoopback(conn *websocket.Conn) {
+	for i, client := range manager.loopbacks {
+		if client.conn == conn {
+			manager.loopbacks = append(manager.loopbacks[:i], manager.loopbacks[i+1:]...)
+			return
+		}
+	}
+}
+
+// readLoopbacks recoge lo que ha llegado de los clientes en memoria, por
+// orden de conexión, y apunta como salida normal a los que han cerrado.
+func (manager *ClientManager) readLoopbacks() []PeerMessage {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+	var received []PeerMessage
+	for _, client := range append([]*LoopbackClient(nil), manager.loopbacks...) {
+		messages, closed := client.up.receive()
+		for _, message := range messages {
+			received = append(received, PeerMessage{Peer: client.conn, Message: message})
+		}
+		if closed {
+			manager.removeLoopback(client.conn)
+			manager.events = append(manager.events, PeerEvent{
+				Kind:     PeerDisconnected,
+				Conn:     client.conn,
+				Player:   manager.players[client.conn],
+				Graceful: true,
+			})
+			delete(manager.players, client.conn)
+		}
+	}
+	return received
+}
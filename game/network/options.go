@@ -0,0 +1,145 @@
+package network
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/demonodojo/rabbits/game/protocol"
+)
+
+const (
+	DefaultMinBackoff = 250 * time.Millisecond
+	DefaultMaxBackoff = 8 * time.Second
+)
+
+// ConnectionState es el estado de la conexión de un cliente, para que la
+// escena pueda mostrarlo.
+type ConnectionState int
+
+const (
+	StateConnecting ConnectionState = iota
+	StateConnected
+	StateReconnecting
+	StateClosed
+)
+
+func (s ConnectionState) String() string {
+	switch s {
+	case StateConnecting:
+		return "connecting"
+	case StateConnected:
+		return "connected"
+	case StateReconnecting:
+		return "reconnecting"
+	}
+	return "closed"
+}
+
+// ClientOptions reúne la configuración de una conexión de cliente.
+type ClientOptions struct {
+	// Encoding es la codificación que usará la escena para sus mensajes. Los
+	// mensajes binarios viajan en tramas binarias y los JSON en tramas de
+	// texto.
+	Encoding protocol.Encoding
+
+	// MinBackoff y MaxBackoff acotan la espera entre intentos de
+	// reconexión, que se duplica en cada intento fallido.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	// Heartbeat configura los pings al servidor. Si el servidor deja de
+	// responder se da la conexión por perdida y se reconecta.
+	Heartbeat Heartbeat
+
+	// Outbound configura la cola de salida. En el cliente los mensajes que
+	// no caben siempre se descartan, según Outbound.Overflow.
+	Outbound Outbound
+
+	// Incoming configura la cola de mensajes recibidos que lee la escena. Si
+	// se deja a cero se guardan hasta 4096 y se descartan los más antiguos.
+	Incoming QueueOptions
+
+	// Link son las condiciones de red simuladas de un cliente en memoria
+	// (Server.Loopback). Las conexiones de verdad no lo usan.
+	Link Link
+}
+
+type ClientOption func(*ClientOptions)
+
+// WithEncoding elige la codificación de la conexión.
+func WithEncoding(encoding protocol.Encoding) ClientOption {
+	return func(o *ClientOptions) {
+		o.Encoding = encoding
+	}
+}
+
+// WithBackoff cambia las esperas mínima y máxima entre reconexiones.
+func WithBackoff(min, max time.Duration) ClientOption {
+	return func(o *ClientOptions) {
+		o.MinBackoff = min
+		o.MaxBackoff = max
+	}
+}
+
+// WithHeartbeat cambia el intervalo de los pings y el plazo para darlos por
+// perdidos.
+func WithHeartbeat(heartbeat Heartbeat) ClientOption {
+	return func(o *ClientOptions) {
+		o.Heartbeat = heartbeat
+	}
+}
+
+// WithOutbound cambia el intervalo de envío y el tamaño de la cola de
+// salida.
+func WithOutbound(outbound Outbound) ClientOption {
+	return func(o *ClientOptions) {
+		o.Outbound = outbound
+	}
+}
+
+// WithIncoming cambia la capacidad y la política de la cola de mensajes
+// recibidos.
+func WithIncoming(incoming QueueOptions) ClientOption {
+	return func(o *ClientOptions) {
+		o.Incoming = incoming
+	}
+}
+
+// WithLink simula latencia, jitter, pérdidas y desorden en un cliente en
+// memoria.
+func WithLink(link Link) ClientOption {
+	return func(o *ClientOptions) {
+		o.Link = link
+	}
+}
+
+func newClientOptions(opts []ClientOption) ClientOptions {
+	options := ClientOptions{
+		MinBackoff: DefaultMinBackoff,
+		MaxBackoff: DefaultMaxBackoff,
+	}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	options.Heartbeat = options.Heartbeat.withDefaults()
+	options.Outbound = options.Outbound.withDefaults()
+	options.Incoming = incomingQueue(options.Incoming, QueueOptions{
+		Capacity: DefaultIncomingCapacity,
+		Overflow: DropOldest,
+	})
+	return options
+}
+
+// backoff es la espera antes del intento attempt, empezando en 0. Se añade
+// hasta un 20% al azar para que los clientes que cayeron a la vez no vuelvan
+// todos a la vez.
+func (o ClientOptions) backoff(attempt int) time.Duration {
+	wait := o.MinBackoff
+	for i := 0; i < attempt && wait < o.MaxBackoff; i++ {
+		wait *= 2
+	}
+	if wait > o.MaxBackoff {
+		wait = o.MaxBackoff
+	}
+	return wait + time.Duration(rand.Int63n(int64(wait)/5+1))
+}
@@ -0,0 +1,94 @@
+package network
+
+import "time"
+
+const (
+	// DefaultMaxPending es cuántos mensajes puede acumular una conexión sin
+	// enviar antes de considerarla lenta.
+	DefaultMaxPending = 1024
+
+	// DefaultIncomingCapacity es cuántos mensajes recibidos se guardan
+	// mientras nadie los lee.
+	DefaultIncomingCapacity = 4096
+)
+
+// SlowPolicy decide qué hacer con una conexión que no da abasto.
+type SlowPolicy int
+
+const (
+	// SlowDisconnect corta la conexión. En el servidor cuenta como una
+	// caída, así que el jugador puede volver dentro del periodo de gracia.
+	SlowDisconnect SlowPolicy = iota
+	// SlowDrop descarta mensajes según Outbound.Overflow.
+	SlowDrop
+)
+
+func (p SlowPolicy) String() string {
+	if p == SlowDrop {
+		return "drop"
+	}
+	return "disconnect"
+}
+
+// Outbound configura la cola de salida de cada conexión. Los mensajes que
+// se acumulan entre dos envíos viajan juntos en una sola trama.
+type Outbound struct {
+	// FlushInterval es el tiempo mínimo entre dos envíos. Con cero se envía
+	// en cuanto hay algo; con más se juntan más mensajes por trama.
+	FlushInterval time.Duration
+
+	// MaxPending es el máximo de mensajes pendientes de enviar. Al pasarlo
+	// se aplica Slow.
+	MaxPending int
+	Slow       SlowPolicy
+
+	// Overflow elige qué mensajes se descartan con SlowDrop. Quien escribe
+	// es el bucle del juego, que no puede esperar a nadie, así que Block se
+	// trata como DropOldest.
+	Overflow OverflowPolicy
+}
+
+// withDefaults rellena los valores que falten.
+func (o Outbound) withDefaults() Outbound {
+	if o.FlushInterval < 0 {
+		o.FlushInterval = 0
+	}
+	if o.MaxPending <= 0 {
+		o.MaxPending = DefaultMaxPending
+	}
+	if o.Overflow == Block {
+		o.Overflow = DropOldest
+	}
+	return o
+}
+
+// queue es la configuración de la cola de salida.
+func (o Outbound) queue() QueueOptions {
+	return QueueOptions{Capacity: o.MaxPending, Overflow: o.Overflow}
+}
+
+// incomingQueue es la configuración de una cola de entrada: si no se indica
+// capacidad se usa def.
+func incomingQueue(options, def QueueOptions) QueueOptions {
+	if options.Capacity <= 0 {
+		return def
+	}
+	return options
+}
+
+// throttle espera a que pase FlushInterval desde el último envío. Devuelve
+// false si stop se cierra antes.
+func (o Outbound) throttle(last time.Time, stop <-chan struct{}) bool {
+	wait := time.Until(last.Add(o.FlushInterval))
+	if wait <= 0 {
+		return true
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-stop:
+		return false
+	}
+}
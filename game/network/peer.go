@@ -2,6 +2,7 @@ package network
 
 import (
 	"log"
+	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -14,15 +15,32 @@ type Peer struct {
 	OutgoingMsg *MessageQueue
 	done        chan struct{}          // Canal para señalizar el cierre
 	events      chan<- *websocket.Conn // Canal para publicar eventos de mensajes
+	unregister  chan<- *websocket.Conn // Canal para avisar de que se ha cortado
+	kick        chan string            // Motivo por el que se cierra la conexión
+	pongs       chan []byte            // Respuestas a los pings de aplicación
+	heartbeat   Heartbeat
+	outbound    Outbound
+	latency     Latency
+	closeErr    error // por qué terminó la lectura
+	closeOnce   sync.Once
+	slowOnce    sync.Once
 }
 
-func NewPeer(conn *websocket.Conn, events chan<- *websocket.Conn) *Peer {
+func NewPeer(conn *websocket.Conn, events chan<- *websocket.Conn, unregister chan<- *websocket.Conn, heartbeat Heartbeat, outbound Outbound) *Peer {
+	outbound = outbound.withDefaults()
 	peer := &Peer{
-		Conn:        conn,
+		Conn: conn,
+		// No hace falta acotarla: readPump no lee el siguiente mensaje hasta
+		// que el ClientManager ha recogido el anterior
 		IncomingMsg: NewMessageQueue(),
-		OutgoingMsg: NewMessageQueue(),
+		OutgoingMsg: NewBoundedMessageQueue(outbound.queue()),
 		done:        make(chan struct{}),
 		events:      events,
+		unregister:  unregister,
+		kick:        make(chan string, 1),
+		pongs:       make(chan []byte, 1),
+		heartbeat:   heartbeat.withDefaults(),
+		outbound:    outbound,
 	}
 	go peer.readPump()
 	go peer.writePump()
@@ -32,7 +50,14 @@ func NewPeer(conn *websocket.Conn, events chan<- *websocket.Conn) *Peer {
 func (p *Peer) readPump() {
 	defer func() {
 		p.Conn.Close()
+		select {
+		case p.unregister <- p.Conn:
+		case <-p.done:
+		}
 	}()
+	// Si deja de responder a los pings la lectura falla por plazo y el peer
+	// se desregistra
+	watch(p.Conn, p.heartbeat, &p.latency)
 	for {
 		select {
 		case <-p.done:
@@ -41,43 +66,124 @@ func (p *Peer) readPump() {
 			_, message, err := p.Conn.ReadMessage()
 			if err != nil {
 				// Manejar error o desconexión
+				log.Println("read:", err)
+				p.closeErr = err
 				return
 			}
-			log.Printf("Mensaje recibido %s\n", string(message))
-			p.IncomingMsg.Enqueue(string(message))
-			p.events <- p.Conn
+			alive(p.Conn, p.heartbeat)
+			if pong, ok := appPong(message); ok {
+				// Si ya hay una respuesta pendiente esta sobra
+				select {
+				case p.pongs <- pong:
+				default:
+				}
+				continue
+			}
+			messages, err := unpack(message)
+			if err != nil {
+				log.Println("read:", err)
+			}
+			for _, message := range messages {
+				log.Printf("Mensaje recibido %s\n", message)
+				p.IncomingMsg.Enqueue(message)
+				select {
+				case p.events <- p.Conn:
+				case <-p.done:
+					return
+				}
+			}
 		}
 	}
 }
 
+// writePump duerme hasta que hay algo que enviar y entonces manda todo lo
+// pendiente de una vez.
 func (p *Peer) writePump() {
-	ticker := time.NewTicker(1 * time.Millisecond)
-	defer ticker.Stop()
+	pinger := time.NewTicker(p.heartbeat.Interval)
+	defer pinger.Stop()
+	var last time.Time
 	for {
 		select {
+		case <-pinger.C:
+			if err := ping(p.Conn, p.heartbeat); err != nil {
+				return
+			}
+		case pong := <-p.pongs:
+			p.Conn.SetWriteDeadline(time.Now().Add(p.heartbeat.Timeout))
+			if err := p.Conn.WriteMessage(websocket.BinaryMessage, pong); err != nil {
+				return
+			}
 		case <-p.done:
 			log.Printf("cerrando la cola...")
 			return // Termina la goroutine si se recibe señal de cierre
-		case <-ticker.C:
-			message, ok := p.OutgoingMsg.Dequeue()
-			if !ok {
+		case reason := <-p.kick:
+			// Se envía lo pendiente antes de cerrar, para que el cliente
+			// reciba el motivo
+			flush(p.Conn, p.OutgoingMsg.ReadAll(), p.heartbeat.Timeout)
+			closeMessage := websocket.FormatCloseMessage(websocket.ClosePolicyViolation, reason)
+			p.Conn.WriteControl(websocket.CloseMessage, closeMessage, time.Now().Add(time.Second))
+			p.Close()
+			return
+		case <-p.OutgoingMsg.Ready():
+			if !p.outbound.throttle(last, p.done) {
+				return
+			}
+			messages := p.OutgoingMsg.ReadAll()
+			if len(messages) == 0 {
 				continue
-			} else {
-				log.Printf("Mensaje enviado %s\n", string(message))
-				if err := p.Conn.WriteMessage(websocket.TextMessage, []byte(message)); err != nil {
-					// Manejar error
-					return
-				}
 			}
+			for _, message := range messages {
+				log.Printf("Mensaje enviado %s\n", message)
+			}
+			if err := flush(p.Conn, messages, p.heartbeat.Timeout); err != nil {
+				log.Println("write:", err)
+				p.Conn.Close() // La lectura falla y el peer se desregistra
+				return
+			}
+			last = time.Now()
 		}
 	}
 }
 
 func (p *Peer) Close() {
-	close(p.done) // Cierra el canal para señalizar a las goroutines que terminen
+	p.closeOnce.Do(func() {
+		close(p.done) // Cierra el canal para señalizar a las goroutines que terminen
+		p.Conn.Close()
+	})
 }
 
+// CloseWithReason envía lo que quede en la cola y cierra la conexión
+// indicando el motivo al otro extremo.
+func (p *Peer) CloseWithReason(reason string) {
+	select {
+	case p.kick <- reason:
+	default:
+	}
+}
+
+// Latency devuelve la latencia medida con los pings.
+func (p *Peer) Latency() LatencyStats {
+	return p.latency.Stats()
+}
+
+// Graceful dice si el otro extremo cerró la conexión a propósito, con una
+// trama de cierre normal, en lugar de perderse.
+func (p *Peer) Graceful() bool {
+	return websocket.IsCloseError(p.closeErr, websocket.CloseNormalClosure, websocket.CloseGoingAway)
+}
+
+// Write encola un mensaje para el cliente. Si el cliente no da abasto se
+// aplica la política de Outbound.
 func (p *Peer) Write(message string) {
+	if p.outbound.Slow == SlowDisconnect && p.OutgoingMsg.Full() {
+		p.slowOnce.Do(func() {
+			log.Printf("cliente %s demasiado lento, desconectando", p.Conn.RemoteAddr())
+			// Basta con cerrar el socket: la lectura falla y el peer se
+			// desregistra como cualquier caída
+			p.Conn.Close()
+		})
+		return
+	}
 	p.OutgoingMsg.Enqueue(message)
 }
 
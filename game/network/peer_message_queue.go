@@ -1,8 +1,9 @@
 package network
 
 import (
+	"fmt"
+
 	"github.com/gorilla/websocket"
-	"sync"
 )
 
 type PeerMessage struct {
@@ -11,35 +12,23 @@ type PeerMessage struct {
 }
 
 type PeerMessageQueue struct {
-	mutex sync.Mutex
-	items []PeerMessage
+	*queue[PeerMessage]
 }
 
 func NewPeerMessageQueue() *PeerMessageQueue {
-	return &PeerMessageQueue{}
+	return NewBoundedPeerMessageQueue(QueueOptions{})
 }
 
-func (q *PeerMessageQueue) Enqueue(item PeerMessage) {
-	q.mutex.Lock()
-	q.items = append(q.items, item)
-	q.mutex.Unlock()
+// NewBoundedPeerMessageQueue crea una PeerMessageQueue acotada. Con
+// Coalesce solo se agrupan los mensajes de la misma conexión.
+func NewBoundedPeerMessageQueue(options QueueOptions) *PeerMessageQueue {
+	return &PeerMessageQueue{newQueue[PeerMessage](options, peerEntityKey)}
 }
 
-func (q *PeerMessageQueue) Dequeue() (PeerMessage, bool) {
-	q.mutex.Lock()
-	defer q.mutex.Unlock()
-	if len(q.items) == 0 {
-		return PeerMessage{}, false
+func peerEntityKey(m PeerMessage) (string, bool) {
+	key, ok := EntityKey(m.Message)
+	if !ok {
+		return "", false
 	}
-	item := q.items[0]
-	q.items = q.items[1:]
-	return item, true
-}
-
-func (q *PeerMessageQueue) ReadAll() []PeerMessage {
-	q.mutex.Lock()
-	defer q.mutex.Unlock()
-	items := q.items
-	q.items = nil // Vacía la cola
-	return items
+	return fmt.Sprintf("%p/%s", m.Peer, key), true
 }
@@ -3,55 +3,243 @@
 package network
 
 import (
+	"errors"
 	"sync"
+
+	"github.com/demonodojo/rabbits/game/protocol"
 )
 
-// MessageQueue representa una cola FIFO segura para concurrencia para almacenar mensajes.
-type MessageQueue struct {
-	mutex sync.Mutex
-	items []string
+// OverflowPolicy decide qué hace una cola acotada cuando se llena.
+type OverflowPolicy int
+
+const (
+	// DropOldest descarta el mensaje más antiguo para hacer sitio.
+	DropOldest OverflowPolicy = iota
+	// DropNewest descarta el mensaje que llega.
+	DropNewest
+	// Coalesce guarda solo el último estado de cada entidad: un mensaje
+	// sustituye al pendiente con el mismo tipo e ID. Si no hay ninguno y la
+	// cola está llena se descarta el más antiguo.
+	Coalesce
+	// Block hace esperar a quien encola hasta que haya sitio.
+	Block
+)
+
+func (p OverflowPolicy) String() string {
+	switch p {
+	case DropNewest:
+		return "drop-newest"
+	case Coalesce:
+		return "coalesce"
+	case Block:
+		return "block"
+	}
+	return "drop-oldest"
 }
 
-// NewMessageQueue crea una nueva instancia de MessageQueue.
-func NewMessageQueue() *MessageQueue {
-	return &MessageQueue{
-		items: make([]string, 0),
+// ParseOverflowPolicy convierte el nombre de una política en su valor.
+func ParseOverflowPolicy(name string) (OverflowPolicy, error) {
+	for _, p := range []OverflowPolicy{DropOldest, DropNewest, Coalesce, Block} {
+		if p.String() == name {
+			return p, nil
+		}
+	}
+	return DropOldest, errors.New("network: unknown overflow policy " + name)
+}
+
+// QueueOptions configura una cola acotada. Con Capacity cero la cola no
+// tiene límite.
+type QueueOptions struct {
+	Capacity int
+	Overflow OverflowPolicy
+}
+
+// QueueStats son las métricas de una cola.
+type QueueStats struct {
+	Len       int // Mensajes pendientes
+	Capacity  int
+	HighWater int    // Máximo de mensajes pendientes que ha llegado a tener
+	Enqueued  uint64 // Mensajes aceptados
+	Dropped   uint64 // Mensajes descartados por falta de sitio
+	Coalesced uint64 // Mensajes sustituidos por uno más nuevo de la misma entidad
+}
+
+// EntityKey es la clave con la que Coalesce agrupa los mensajes: el tipo y la
+// entidad de la cabecera del sobre, sin decodificar la carga. Los mensajes sin
+// entidad no se agrupan, y los Input tampoco: cada uno es el comando de un
+// tick y perderlo cambia la simulación.
+func EntityKey(message string) (string, bool) {
+	env, err := protocol.Peek(message)
+	if err != nil || env.Entity == "" || env.Type == "Input" {
+		return "", false
+	}
+	return env.Type + "/" + env.Entity, true
+}
+
+// queue es la cola FIFO segura para concurrencia sobre la que se montan
+// MessageQueue y PeerMessageQueue.
+type queue[T any] struct {
+	mutex   sync.Mutex
+	space   *sync.Cond // Avisa a los bloqueados en Enqueue de que hay sitio
+	items   []T
+	keys    []string // Clave de cada elemento, solo con Coalesce
+	key     func(T) (string, bool)
+	options QueueOptions
+	stats   QueueStats
+	ready   chan struct{} // Avisa de que hay mensajes nuevos
+	closed  bool
+}
+
+func newQueue[T any](options QueueOptions, key func(T) (string, bool)) *queue[T] {
+	q := &queue[T]{
+		items:   make([]T, 0),
+		key:     key,
+		options: options,
+		ready:   make(chan struct{}, 1),
 	}
+	q.space = sync.NewCond(&q.mutex)
+	q.stats.Capacity = options.Capacity
+	return q
 }
 
-// Enqueue agrega un elemento al final de la cola.
-func (q *MessageQueue) Enqueue(item string) {
+func (q *queue[T]) full() bool {
+	return q.options.Capacity > 0 && len(q.items) >= q.options.Capacity
+}
+
+// Enqueue agrega un elemento al final de la cola, aplicando la política de
+// desbordamiento si no cabe.
+func (q *queue[T]) Enqueue(item T) {
 	q.mutex.Lock()
 	defer q.mutex.Unlock()
+
+	key, keyed := "", false
+	if q.options.Overflow == Coalesce {
+		key, keyed = q.key(item)
+		if keyed {
+			for i, k := range q.keys {
+				if k == key {
+					q.items[i] = item
+					q.stats.Coalesced++
+					return
+				}
+			}
+		}
+	}
+
+	for q.full() {
+		switch q.options.Overflow {
+		case DropNewest:
+			q.stats.Dropped++
+			return
+		case Block:
+			if q.closed {
+				q.stats.Dropped++
+				return
+			}
+			q.space.Wait()
+			continue
+		}
+		q.items = q.items[1:]
+		if len(q.keys) > 0 {
+			q.keys = q.keys[1:]
+		}
+		q.stats.Dropped++
+	}
+
 	q.items = append(q.items, item)
+	if q.options.Overflow == Coalesce {
+		q.keys = append(q.keys, key)
+	}
+	q.stats.Enqueued++
+	if len(q.items) > q.stats.HighWater {
+		q.stats.HighWater = len(q.items)
+	}
+	select {
+	case q.ready <- struct{}{}:
+	default: // Ya había un aviso pendiente
+	}
 }
 
-// Dequeue elimina y devuelve el primer elemento de la cola.
-// Devuelve "", false si la cola está vacía.
-func (q *MessageQueue) Dequeue() (string, bool) {
+// Dequeue elimina y devuelve el primer elemento de la cola. Devuelve false
+// si la cola está vacía.
+func (q *queue[T]) Dequeue() (T, bool) {
 	q.mutex.Lock()
 	defer q.mutex.Unlock()
 
+	var item T
 	if len(q.items) == 0 {
-		return "", false
+		return item, false
 	}
 
-	item := q.items[0]
+	item = q.items[0]
 	q.items = q.items[1:] // Deslizar el slice para remover el elemento.
+	if len(q.keys) > 0 {
+		q.keys = q.keys[1:]
+	}
+	q.space.Broadcast()
 	return item, true
 }
 
 // Size devuelve el número actual de elementos en la cola.
-func (q *MessageQueue) Size() int {
+func (q *queue[T]) Size() int {
 	q.mutex.Lock()
 	defer q.mutex.Unlock()
 	return len(q.items)
 }
 
-func (q *MessageQueue) ReadAll() []string {
+// Full dice si la cola está llena.
+func (q *queue[T]) Full() bool {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return q.full()
+}
+
+func (q *queue[T]) ReadAll() []T {
 	q.mutex.Lock()
 	defer q.mutex.Unlock()
 	items := q.items
 	q.items = nil // Vacía la cola
+	q.keys = nil
+	q.space.Broadcast()
 	return items
 }
+
+// Ready avisa cuando se encola algo. Un solo aviso puede cubrir varios
+// mensajes, así que quien lo recibe debe vaciar la cola entera.
+func (q *queue[T]) Ready() <-chan struct{} {
+	return q.ready
+}
+
+// Stats devuelve las métricas de la cola.
+func (q *queue[T]) Stats() QueueStats {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	stats := q.stats
+	stats.Len = len(q.items)
+	return stats
+}
+
+// Close libera a los que esperan en Enqueue. A partir de entonces lo que no
+// quepa se descarta en lugar de esperar.
+func (q *queue[T]) Close() {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	q.closed = true
+	q.space.Broadcast()
+}
+
+// MessageQueue representa una cola FIFO segura para concurrencia para almacenar mensajes.
+type MessageQueue struct {
+	*queue[string]
+}
+
+// NewMessageQueue crea una nueva instancia de MessageQueue sin límite.
+func NewMessageQueue() *MessageQueue {
+	return NewBoundedMessageQueue(QueueOptions{})
+}
+
+// NewBoundedMessageQueue crea una MessageQueue acotada. Con Coalesce los
+// mensajes se agrupan por EntityKey.
+func NewBoundedMessageQueue(options QueueOptions) *MessageQueue {
+	return &MessageQueue{newQueue[string](options, EntityKey)}
+}
@@ -0,0 +1,60 @@
+package network_test
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+
+	"github.com/demonodojo/rabbits/game/network"
+	"github.com/demonodojo/rabbits/game/protocol"
+	"github.com/demonodojo/rabbits/game/sim"
+)
+
+func encode(t *testing.T, encoding protocol.Encoding, v interface{}) string {
+	t.Helper()
+	message, err := protocol.EncodeAs(encoding, v, 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return message
+}
+
+// Coalesce se queda con el último estado de cada entidad, pero nunca junta
+// dos Input: cada uno es el comando de un tick.
+func TestCoalesceKeepsInputs(t *testing.T) {
+	for _, encoding := range []protocol.Encoding{protocol.EncodingJSON, protocol.EncodingBinary} {
+		q := network.NewBoundedMessageQueue(network.QueueOptions{Capacity: 16, Overflow: network.Coalesce})
+		rabbit := sim.NewRabbit(uuid.New())
+		for i := uint32(1); i <= 3; i++ {
+			q.Enqueue(encode(t, encoding, sim.NewInput(rabbit.ID, i)))
+			rabbit.Position.X = float64(i)
+			q.Enqueue(encode(t, encoding, rabbit))
+		}
+
+		types := map[string]int{}
+		for _, message := range q.ReadAll() {
+			env, err := protocol.Peek(message)
+			if err != nil {
+				t.Fatal(err)
+			}
+			types[env.Type]++
+		}
+		if types["Input"] != 3 || types["Rabbit"] != 1 {
+			t.Errorf("%v: queue kept %v, want 3 Input and 1 Rabbit", encoding, types)
+		}
+	}
+}
+
+// La clave sale de la cabecera: la entidad viaja en el sobre.
+func TestEntityKeyFromHeader(t *testing.T) {
+	rabbit := sim.NewRabbit(uuid.New())
+	for _, encoding := range []protocol.Encoding{protocol.EncodingJSON, protocol.EncodingBinary} {
+		key, ok := network.EntityKey(encode(t, encoding, rabbit))
+		if want := "Rabbit/" + rabbit.ID.String(); !ok || key != want {
+			t.Errorf("%v: EntityKey = %q, %v, want %q", encoding, key, ok, want)
+		}
+		if _, ok := network.EntityKey(encode(t, encoding, &protocol.Leave{ID: rabbit.ID})); ok {
+			t.Errorf("%v: message without entity got a key", encoding)
+		}
+	}
+}
@@ -3,51 +3,258 @@
 package network
 
 import (
-	"github.com/gorilla/websocket"
+	"context"
 	"log"
+	"net"
 	"net/http"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+
+	"github.com/demonodojo/rabbits/game/protocol"
+)
+
+const (
+	DefaultPath = "/ws"
+
+	// DefaultReadLimit es el tamaño máximo de una trama de un cliente.
+	DefaultReadLimit = 64 << 10
 )
 
 type Server struct {
 	Port string // Dirección en la que el servidor escuchará
+	Path string // Ruta del endpoint WebSocket, "/ws" si se deja vacía
+
+	// CheckOrigin decide si se acepta una conexión según su origen. Si es nil
+	// se acepta cualquier origen.
+	CheckOrigin func(r *http.Request) bool
+
+	// Heartbeat configura los pings con los que se detectan los clientes que
+	// ya no responden. Si se deja a cero se usan los valores por defecto.
+	Heartbeat Heartbeat
+
+	// Outbound configura cada cuánto se envía a los clientes y qué hacer con
+	// los que no leen a tiempo.
+	Outbound Outbound
+
+	// Incoming configura la cola con los mensajes recibidos de todos los
+	// clientes. Si se deja a cero se guardan hasta 4096 y, al llenarse, se
+	// deja de leer de los clientes hasta que haya sitio.
+	Incoming QueueOptions
+
+	// ReadLimit es el tamaño máximo en bytes de una trama recibida. A quien
+	// lo supera se le corta la conexión. Si se deja a cero es
+	// DefaultReadLimit.
+	ReadLimit int64
+
+	// Name es el nombre con el que el servidor se anuncia en la red local. Si
+	// se deja vacío se usa el de la máquina.
+	Name string
+
+	// Discovery es la dirección UDP en la que se responde a las sondas de
+	// Discover, normalmente DefaultDiscoveryAddr. Si se deja vacía no se
+	// responde.
+	Discovery string
+
+	once          sync.Once
+	upgrader      websocket.Upgrader
+	clientManager *ClientManager
+	httpServer    *http.Server
+	listener      net.Listener
+	discoveryConn net.PacketConn
+
+	id            string // Distingue al servidor aunque responda por varias redes
+	announceMutex sync.Mutex
+	rooms         []protocol.RoomInfo
+}
+
+// NewServer crea un servidor que escuchará en port con la configuración por
+// defecto.
+func NewServer(port string) *Server {
+	return &Server{Port: port}
 }
 
-var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool {
-		return true // Aceptar cualquier origen por simplicidad
-	},
+func acceptAnyOrigin(r *http.Request) bool {
+	return true // Aceptar cualquier origen por simplicidad
 }
 
-var clientManager = NewClientManager()
+// init prepara el servidor la primera vez que se usa, para que también
+// funcione uno creado como literal.
+func (s *Server) init() {
+	s.once.Do(func() {
+		if s.Path == "" {
+			s.Path = DefaultPath
+		}
+		s.id = uuid.NewString()
+		checkOrigin := s.CheckOrigin
+		if checkOrigin == nil {
+			checkOrigin = acceptAnyOrigin
+		}
+		s.upgrader = websocket.Upgrader{CheckOrigin: checkOrigin}
+		s.clientManager = NewClientManager(incomingQueue(s.Incoming, QueueOptions{
+			Capacity: DefaultIncomingCapacity,
+			Overflow: Block,
+		}))
+		s.clientManager.Heartbeat = s.Heartbeat
+		s.clientManager.Outbound = s.Outbound
+		s.clientManager.ReadLimit = s.ReadLimit
+		if s.ReadLimit <= 0 {
+			s.clientManager.ReadLimit = DefaultReadLimit
+		}
+		go s.clientManager.Run()
+	})
+}
 
 func (s *Server) handleConnections(w http.ResponseWriter, r *http.Request) {
-	ws, err := upgrader.Upgrade(w, r, nil)
+	ws, err := s.upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Println("Error al actualizar WebSocket:", err)
 		return
 	}
 	log.Println("Nueva Conexión")
-	clientManager.RegisterClient(ws)
+	s.clientManager.RegisterClient(ws)
 
 }
 
-func (s *Server) Start() {
+// Handler devuelve el http.Handler del servidor, para montarlo en otro
+// servidor HTTP o en un httptest.Server.
+func (s *Server) Handler() http.Handler {
+	s.init()
+	mux := http.NewServeMux()
+	mux.HandleFunc(s.Path, s.handleConnections)
+	return mux
+}
 
-	go clientManager.Run()
+// Start empieza a escuchar en Port y atiende las conexiones en segundo
+// plano. Si no puede escuchar, por ejemplo porque el puerto está ocupado,
+// devuelve el error sin más: el proceso sigue vivo.
+func (s *Server) Start() error {
+	s.init()
+	listener, err := net.Listen("tcp", s.Port)
+	if err != nil {
+		return err
+	}
+	s.listener = listener
+	s.httpServer = &http.Server{Handler: s.Handler()}
 
 	go func() {
-		http.HandleFunc("/ws", s.handleConnections)
-		log.Printf("Iniciando servidor WebSocket en %s\n", s.Port)
-		if err := http.ListenAndServe(s.Port, nil); err != nil {
-			log.Fatal("Error iniciando servidor WebSocket:", err)
+		log.Printf("Iniciando servidor WebSocket en %s%s\n", listener.Addr(), s.Path)
+		if err := s.httpServer.Serve(listener); err != nil && err != http.ErrServerClosed {
+			log.Println("Error en el servidor WebSocket:", err)
 		}
 	}()
+
+	if s.Discovery != "" {
+		conn, err := net.ListenPacket("udp", s.Discovery)
+		if err != nil {
+			// Sin descubrimiento el servidor sigue funcionando.
+			log.Println("Error iniciando el descubrimiento:", err)
+			return nil
+		}
+		log.Printf("Respondiendo a sondas de descubrimiento en %s\n", conn.LocalAddr())
+		s.discoveryConn = conn
+		go s.serveDiscovery(conn)
+	}
+	return nil
+}
+
+// Addr devuelve la dirección en la que escucha el servidor tras Start, útil
+// cuando Port deja que el sistema elija el puerto. Antes de Start es nil.
+func (s *Server) Addr() net.Addr {
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Addr()
+}
+
+// DiscoveryAddr devuelve la dirección UDP en la que se responden las sondas
+// tras Start, o nil si no se responden.
+func (s *Server) DiscoveryAddr() net.Addr {
+	if s.discoveryConn == nil {
+		return nil
+	}
+	return s.discoveryConn.LocalAddr()
+}
+
+// Shutdown deja de aceptar conexiones y cierra las que haya abiertas.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.init()
+	var err error
+	if s.httpServer != nil {
+		err = s.httpServer.Shutdown(ctx)
+	}
+	if s.discoveryConn != nil {
+		s.discoveryConn.Close()
+	}
+	s.clientManager.Close()
+	return err
+}
+
+// SetRooms cambia las salas que se anuncian a quien busca servidores.
+func (s *Server) SetRooms(rooms []protocol.RoomInfo) {
+	s.init()
+	s.announceMutex.Lock()
+	defer s.announceMutex.Unlock()
+	s.rooms = rooms
+}
+
+func (s *Server) ClientManager() *ClientManager {
+	s.init()
+	return s.clientManager
 }
 
 func (s *Server) ReadAll() []PeerMessage {
-	return clientManager.allMessages.ReadAll()
+	s.init()
+	return s.clientManager.ReadAll()
+}
+
+// Latency devuelve el RTT y el jitter medidos con el cliente de conn.
+func (s *Server) Latency(conn *websocket.Conn) (LatencyStats, bool) {
+	s.init()
+	return s.clientManager.Latency(conn)
+}
+
+// IncomingStats devuelve las métricas de la cola de mensajes recibidos.
+func (s *Server) IncomingStats() QueueStats {
+	s.init()
+	return s.clientManager.Stats()
+}
+
+// OutgoingStats devuelve las métricas de la cola de salida del cliente de
+// conn.
+func (s *Server) OutgoingStats(conn *websocket.Conn) (QueueStats, bool) {
+	s.init()
+	return s.clientManager.PeerStats(conn)
+}
+
+// Bind asocia una conexión con su jugador, para que los eventos de entrada y
+// salida lo incluyan.
+func (s *Server) Bind(conn *websocket.Conn, player uuid.UUID) {
+	s.init()
+	s.clientManager.Bind(conn, player)
+}
+
+// Events devuelve las entradas y salidas de jugadores desde la última
+// llamada.
+func (s *Server) Events() []PeerEvent {
+	s.init()
+	return s.clientManager.Events()
+}
+
+// Send envía un mensaje solo al cliente de conn.
+func (s *Server) Send(conn *websocket.Conn, message string) {
+	s.init()
+	s.clientManager.Write(conn, message)
+}
+
+// Disconnect echa a un cliente indicándole el motivo.
+func (s *Server) Disconnect(conn *websocket.Conn, reason string) {
+	s.init()
+	s.clientManager.Disconnect(conn, reason)
 }
 
 func (s *Server) Broadcast(message string) {
-	clientManager.Broadcast(message)
+	s.init()
+	s.clientManager.Broadcast(message)
 }
@@ -0,0 +1,102 @@
+package network_test
+
+import (
+	"context"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/demonodojo/rabbits/game/network"
+)
+
+// dial conecta un websocket al servidor de pruebas ts.
+func dial(t *testing.T, ts *httptest.Server) *websocket.Conn {
+	t.Helper()
+	url := "ws" + strings.TrimPrefix(ts.URL, "http") + network.DefaultPath
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("dial %s: %v", url, err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// readAll espera hasta que server haya recibido n mensajes.
+func readAll(t *testing.T, server *network.Server, n int) []string {
+	t.Helper()
+	var messages []string
+	deadline := time.Now().Add(2 * time.Second)
+	for len(messages) < n && time.Now().Before(deadline) {
+		for _, msg := range server.ReadAll() {
+			messages = append(messages, msg.Message)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	return messages
+}
+
+// Dos servidores en el mismo proceso no comparten clientes ni mensajes.
+func TestServersAreIsolated(t *testing.T) {
+	servers := []*network.Server{network.NewServer(""), network.NewServer("")}
+	conns := make([]*websocket.Conn, len(servers))
+	for i, server := range servers {
+		ts := httptest.NewServer(server.Handler())
+		t.Cleanup(ts.Close)
+		t.Cleanup(func() { server.Shutdown(context.Background()) })
+		conns[i] = dial(t, ts)
+	}
+
+	for i, conn := range conns {
+		if err := conn.WriteMessage(websocket.TextMessage, []byte([]string{"to-a", "to-b"}[i])); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i, want := range []string{"to-a", "to-b"} {
+		got := readAll(t, servers[i], 1)
+		if len(got) != 1 || got[0] != want {
+			t.Errorf("server %d received %q, want [%q]", i, got, want)
+		}
+		if clients := servers[i].ClientManager().GetClients(); len(clients) != 1 {
+			t.Errorf("server %d has %d clients, want 1", i, len(clients))
+		}
+	}
+}
+
+// Un puerto ocupado es un error de Start, no el fin del proceso.
+func TestStartReturnsListenError(t *testing.T) {
+	busy, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer busy.Close()
+
+	server := network.NewServer(busy.Addr().String())
+	defer server.Shutdown(context.Background())
+	if err := server.Start(); err == nil {
+		t.Fatalf("Start on busy %s succeeded", busy.Addr())
+	}
+}
+
+// Con el puerto 0 el sistema elige uno libre, y Addr dice cuál.
+func TestStartOnAnyPort(t *testing.T) {
+	server := network.NewServer("127.0.0.1:0")
+	if err := server.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer server.Shutdown(context.Background())
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws://"+server.Addr().String()+network.DefaultPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.WriteMessage(websocket.TextMessage, []byte("ping"))
+	if got := readAll(t, server, 1); len(got) != 1 || got[0] != "ping" {
+		t.Errorf("received %q, want [ping]", got)
+	}
+}
@@ -66,8 +66,8 @@ func (p *Player) Update() {
 		halfH := float64(bounds.Dy()) / 2
 
 		spawnPos := Vector{
-			p.position.X + halfW + math.Sin(p.rotation)*bulletSpawnOffset,
-			p.position.Y + halfH + math.Cos(p.rotation)*-bulletSpawnOffset,
+			X: p.position.X + halfW + math.Sin(p.rotation)*bulletSpawnOffset,
+			Y: p.position.Y + halfH + math.Cos(p.rotation)*-bulletSpawnOffset,
 		}
 
 		bullet := NewBullet(spawnPos, p.rotation)
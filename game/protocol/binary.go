@@ -0,0 +1,235 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+
+	"github.com/google/uuid"
+)
+
+// Encoding es la forma en que se codifican los sobres de una conexión.
+type Encoding int
+
+const (
+	// EncodingJSON es legible y cómoda para depurar.
+	EncodingJSON Encoding = iota
+	// EncodingBinary es compacta: varints, floats cuantizados e IDs de 16 bytes.
+	EncodingBinary
+)
+
+func (e Encoding) String() string {
+	if e == EncodingBinary {
+		return "binary"
+	}
+	return "json"
+}
+
+// ParseEncoding convierte "json" o "binary" en su Encoding.
+func ParseEncoding(name string) (Encoding, error) {
+	switch name {
+	case "json", "":
+		return EncodingJSON, nil
+	case "binary":
+		return EncodingBinary, nil
+	}
+	return EncodingJSON, errors.New("protocol: unknown encoding " + name)
+}
+
+const (
+	// binaryMagic abre todo sobre binario; un sobre JSON siempre empieza por '{'.
+	binaryMagic = 0xB7
+
+	payloadJSON   = 0
+	payloadBinary = 1
+
+	positionScale = 16
+	speedScale    = 100
+	rotationSteps = 1 << 16
+)
+
+var ErrShortBuffer = errors.New("protocol: short buffer")
+
+// BinaryCodec es el códec compacto de un tipo de mensaje. Los tipos sin él
+// viajan con su carga en JSON dentro del sobre binario.
+type BinaryCodec interface {
+	MarshalBinary(v interface{}, w *Writer) error
+	UnmarshalBinary(r *Reader) (interface{}, error)
+}
+
+// IsBinary dice si un mensaje recibido es un sobre binario.
+func IsBinary(message string) bool {
+	return len(message) > 0 && message[0] == binaryMagic
+}
+
+// Writer va añadiendo campos codificados a un buffer.
+type Writer struct {
+	buf []byte
+}
+
+func (w *Writer) Bytes() []byte {
+	return w.buf
+}
+
+func (w *Writer) Byte(b byte) {
+	w.buf = append(w.buf, b)
+}
+
+func (w *Writer) Uvarint(v uint64) {
+	w.buf = binary.AppendUvarint(w.buf, v)
+}
+
+func (w *Writer) Varint(v int64) {
+	w.buf = binary.AppendVarint(w.buf, v)
+}
+
+func (w *Writer) Bool(v bool) {
+	if v {
+		w.Byte(1)
+	} else {
+		w.Byte(0)
+	}
+}
+
+func (w *Writer) String(s string) {
+	w.Uvarint(uint64(len(s)))
+	w.buf = append(w.buf, s...)
+}
+
+func (w *Writer) Raw(b []byte) {
+	w.buf = append(w.buf, b...)
+}
+
+func (w *Writer) UUID(id uuid.UUID) {
+	w.buf = append(w.buf, id[:]...)
+}
+
+// Position guarda una coordenada con precisión de 1/16 de píxel.
+func (w *Writer) Position(v float64) {
+	w.Varint(int64(math.Round(v * positionScale)))
+}
+
+// Speed guarda una velocidad con precisión de centésimas.
+func (w *Writer) Speed(v float64) {
+	w.Varint(int64(math.Round(v * speedScale)))
+}
+
+// Rotation guarda un ángulo normalizado a [0, 2π) en 16 bits.
+func (w *Writer) Rotation(v float64) {
+	turns := math.Mod(v, 2*math.Pi)
+	if turns < 0 {
+		turns += 2 * math.Pi
+	}
+	step := uint16(int(math.Round(turns/(2*math.Pi)*rotationSteps)) % rotationSteps)
+	w.buf = binary.BigEndian.AppendUint16(w.buf, step)
+}
+
+// Reader lee campos codificados por Writer. El primer error se guarda y las
+// lecturas siguientes devuelven ceros, así que basta comprobar Err al final.
+type Reader struct {
+	buf []byte
+	err error
+}
+
+func NewReader(buf []byte) *Reader {
+	return &Reader{buf: buf}
+}
+
+func (r *Reader) Err() error {
+	return r.err
+}
+
+// Fail marca la lectura como fallida; sirve a los códecs para validar.
+func (r *Reader) Fail(err error) {
+	if r.err == nil {
+		r.err = err
+	}
+	r.buf = nil
+}
+
+func (r *Reader) Byte() byte {
+	if len(r.buf) < 1 {
+		r.Fail(ErrShortBuffer)
+		return 0
+	}
+	b := r.buf[0]
+	r.buf = r.buf[1:]
+	return b
+}
+
+func (r *Reader) Uvarint() uint64 {
+	v, n := binary.Uvarint(r.buf)
+	if n <= 0 {
+		r.Fail(ErrShortBuffer)
+		return 0
+	}
+	r.buf = r.buf[n:]
+	return v
+}
+
+func (r *Reader) Varint() int64 {
+	v, n := binary.Varint(r.buf)
+	if n <= 0 {
+		r.Fail(ErrShortBuffer)
+		return 0
+	}
+	r.buf = r.buf[n:]
+	return v
+}
+
+func (r *Reader) Bool() bool {
+	return r.Byte() != 0
+}
+
+func (r *Reader) String() string {
+	n := r.Uvarint()
+	if uint64(len(r.buf)) < n {
+		r.Fail(ErrShortBuffer)
+		return ""
+	}
+	s := string(r.buf[:n])
+	r.buf = r.buf[n:]
+	return s
+}
+
+// More dice si quedan bytes por leer. Sirve para los campos opcionales que
+// se añaden al final de un mensaje.
+func (r *Reader) More() bool {
+	return len(r.buf) > 0
+}
+
+// Rest devuelve lo que queda sin leer.
+func (r *Reader) Rest() []byte {
+	rest := r.buf
+	r.buf = nil
+	return rest
+}
+
+func (r *Reader) UUID() uuid.UUID {
+	var id uuid.UUID
+	if len(r.buf) < len(id) {
+		r.Fail(ErrShortBuffer)
+		return id
+	}
+	copy(id[:], r.buf)
+	r.buf = r.buf[len(id):]
+	return id
+}
+
+func (r *Reader) Position() float64 {
+	return float64(r.Varint()) / positionScale
+}
+
+func (r *Reader) Speed() float64 {
+	return float64(r.Varint()) / speedScale
+}
+
+func (r *Reader) Rotation() float64 {
+	if len(r.buf) < 2 {
+		r.Fail(ErrShortBuffer)
+		return 0
+	}
+	step := binary.BigEndian.Uint16(r.buf)
+	r.buf = r.buf[2:]
+	return float64(step) / rotationSteps * 2 * math.Pi
+}
@@ -0,0 +1,36 @@
+package protocol
+
+import "github.com/google/uuid"
+
+// Canales del chat.
+const (
+	// ChannelRoom llega a todos los que están en la sala, espectadores
+	// incluidos.
+	ChannelRoom = "room"
+	// ChannelTeam llega solo a los jugadores del mismo equipo.
+	ChannelTeam = "team"
+	// ChannelSystem es el de los avisos del servidor: quién entra y sale,
+	// quién le da a quién y quién va ganando.
+	ChannelSystem = "system"
+)
+
+// Chat es un mensaje que un cliente quiere decir en un canal. El canal vacío
+// es el de la sala.
+type Chat struct {
+	Channel string `json:"channel,omitempty"`
+	Text    string `json:"text"`
+}
+
+// ChatMessage es un mensaje de chat repartido por el servidor, ya filtrado.
+// En los avisos del sistema From es cero y Name está vacío.
+type ChatMessage struct {
+	Channel string    `json:"channel"`
+	From    uuid.UUID `json:"from"`
+	Name    string    `json:"name,omitempty"`
+	Text    string    `json:"text"`
+}
+
+func init() {
+	Register[Chat]("chat")
+	Register[ChatMessage]("chat_message")
+}
@@ -0,0 +1,46 @@
+package protocol
+
+// Mensajes del vestíbulo. Un cliente que acaba de conectar está en el
+// vestíbulo: puede pedir la lista de salas, crear una o entrar en una
+// saludando con Hello.Room. Desde una sala puede volver con LeaveRoom.
+
+// RoomInfo describe una sala en la lista.
+type RoomInfo struct {
+	Name       string `json:"name"`
+	Players    int    `json:"players"`
+	MaxPlayers int    `json:"max_players"`
+	Spectators int    `json:"spectators,omitempty"`
+}
+
+// ListRooms pide la lista de salas.
+type ListRooms struct{}
+
+// RoomList es la respuesta a ListRooms, a CreateRoom y a LeaveRoom.
+type RoomList struct {
+	Rooms []RoomInfo `json:"rooms"`
+}
+
+// CreateRoom pide una sala nueva. Con MaxPlayers cero el servidor elige el
+// tope. Para entrar hay que saludar después con su nombre.
+type CreateRoom struct {
+	Name       string `json:"name"`
+	MaxPlayers int    `json:"max_players,omitempty"`
+}
+
+// LeaveRoom saca al jugador de su sala y lo devuelve al vestíbulo sin
+// cerrar la conexión.
+type LeaveRoom struct{}
+
+// LobbyError explica por qué no se ha podido atender una petición del
+// vestíbulo. A diferencia de Reject, la conexión sigue abierta.
+type LobbyError struct {
+	Reason string `json:"reason"`
+}
+
+func init() {
+	Register[ListRooms]("list_rooms")
+	Register[RoomList]("room_list")
+	Register[CreateRoom]("create_room")
+	Register[LeaveRoom]("leave_room")
+	Register[LobbyError]("lobby_error")
+}
@@ -0,0 +1,100 @@
+// Package protocol define el formato de los mensajes entre cliente y
+// servidor: un sobre con versión, tipo, secuencia y tick, y una carga útil
+// que codifica el códec registrado para ese tipo.
+package protocol
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// Version es la versión del protocolo que habla este binario. La 2
+	// añade la entidad a la cabecera.
+	Version = 2
+	// MinVersion es la versión más antigua que todavía se acepta. Un
+	// cliente binario de la 1 no sabría leer la cabecera nueva.
+	MinVersion = 2
+)
+
+// Envelope es lo que viaja por el cable.
+type Envelope struct {
+	Version  int    `json:"v"`
+	Type     string `json:"type"`
+	Sequence uint32 `json:"seq"`
+	Tick     uint64 `json:"tick"`
+	// Entity es el ID de la entidad de la carga, si la tiene, para que las
+	// colas puedan agrupar mensajes sin decodificarla.
+	Entity  string          `json:"entity,omitempty"`
+	Payload json.RawMessage `json:"payload"`
+
+	// Encoding es la codificación con la que llegó el sobre.
+	Encoding Encoding `json:"-"`
+}
+
+// Hello es lo primero que manda un cliente al conectar. Token es el que le
+// dio el servidor en una conexión anterior, para recuperar su conejo. Room
+// es la sala en la que quiere jugar; vacía es la sala por defecto. Con
+// Spectate el cliente solo mira: recibe el mundo pero no tiene conejo. Team
+// es el equipo con el que comparte el canal de chat de equipo.
+type Hello struct {
+	Version  int    `json:"version"`
+	Name     string `json:"name,omitempty"`
+	Token    string `json:"token,omitempty"`
+	Room     string `json:"room,omitempty"`
+	Spectate bool   `json:"spectate,omitempty"`
+	Team     string `json:"team,omitempty"`
+}
+
+// Welcome acepta al cliente y le dice qué conejo es el suyo. El estado del
+// mundo llega justo después en un snapshot completo. A un espectador se le
+// acepta con Spectator y sin conejo ni token. LastInput es el último comando
+// que el servidor ha aplicado a ese conejo: los siguientes deben numerarse a
+// partir de él.
+type Welcome struct {
+	Version   int       `json:"version"`
+	ID        uuid.UUID `json:"id"`
+	Name      string    `json:"name"`
+	SpawnX    float64   `json:"spawn_x"`
+	SpawnY    float64   `json:"spawn_y"`
+	Token     string    `json:"token"`
+	Room      string    `json:"room,omitempty"`
+	Spectator bool      `json:"spectator,omitempty"`
+	LastInput uint32    `json:"last_input,omitempty"`
+}
+
+// Reject explica al cliente por qué se le rechaza antes de desconectarlo.
+type Reject struct {
+	Reason string `json:"reason"`
+}
+
+// Leave avisa de que un jugador se ha ido de la partida y su conejo ya no
+// está.
+type Leave struct {
+	ID     uuid.UUID `json:"id"`
+	Name   string    `json:"name"`
+	Reason string    `json:"reason"`
+}
+
+func init() {
+	Register[Hello]("hello")
+	Register[Welcome]("welcome")
+	Register[Reject]("reject")
+	Register[Leave]("leave")
+}
+
+// Supported dice si se puede hablar con un cliente de la versión dada.
+func Supported(version int) bool {
+	return version >= MinVersion && version <= Version
+}
+
+// CheckHello comprueba la versión de un saludo y devuelve el motivo del
+// rechazo si no es compatible.
+func CheckHello(hello *Hello) error {
+	if !Supported(hello.Version) {
+		return fmt.Errorf("protocol version %d not supported, server speaks %d to %d", hello.Version, MinVersion, Version)
+	}
+	return nil
+}
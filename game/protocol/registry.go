@@ -0,0 +1,297 @@
+package protocol
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// Codec convierte un tipo de mensaje en la carga útil del sobre y al revés.
+type Codec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte) (interface{}, error)
+}
+
+type jsonCodec[T any] struct{}
+
+func (jsonCodec[T]) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec[T]) Unmarshal(data []byte) (interface{}, error) {
+	v := new(T)
+	if err := json.Unmarshal(data, v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// Registry asocia nombres de tipo de mensaje con su tipo Go y su códec.
+type Registry struct {
+	mutex  sync.RWMutex
+	codecs map[string]Codec
+	binary map[string]BinaryCodec
+	names  map[reflect.Type]string
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		codecs: make(map[string]Codec),
+		binary: make(map[string]BinaryCodec),
+		names:  make(map[reflect.Type]string),
+	}
+}
+
+// DefaultRegistry es el registro en el que se apuntan los tipos del juego.
+var DefaultRegistry = NewRegistry()
+
+// Register apunta en DefaultRegistry el tipo T con un códec JSON.
+func Register[T any](name string) {
+	DefaultRegistry.Register(name, reflect.TypeOf((*T)(nil)), jsonCodec[T]{})
+}
+
+// Register asocia name con el tipo puntero typ y su códec.
+func (r *Registry) Register(name string, typ reflect.Type, codec Codec) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if typ.Kind() != reflect.Pointer {
+		typ = reflect.PointerTo(typ)
+	}
+	r.codecs[name] = codec
+	r.names[typ] = name
+}
+
+// RegisterBinary añade el códec compacto de un tipo ya registrado con name.
+func (r *Registry) RegisterBinary(name string, codec BinaryCodec) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.binary[name] = codec
+}
+
+// RegisterBinary usa DefaultRegistry.
+func RegisterBinary(name string, codec BinaryCodec) {
+	DefaultRegistry.RegisterBinary(name, codec)
+}
+
+// Name devuelve el nombre registrado para el tipo de v.
+func (r *Registry) Name(v interface{}) (string, bool) {
+	typ := reflect.TypeOf(v)
+	if typ == nil {
+		return "", false
+	}
+	if typ.Kind() != reflect.Pointer {
+		typ = reflect.PointerTo(typ)
+	}
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	name, ok := r.names[typ]
+	return name, ok
+}
+
+func (r *Registry) codec(name string) (Codec, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	codec, ok := r.codecs[name]
+	return codec, ok
+}
+
+func (r *Registry) binaryCodec(name string) (BinaryCodec, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	codec, ok := r.binary[name]
+	return codec, ok
+}
+
+// Encode mete v en un sobre JSON de la versión actual.
+func (r *Registry) Encode(v interface{}, sequence uint32, tick uint64) (string, error) {
+	return r.EncodeAs(EncodingJSON, v, sequence, tick)
+}
+
+// EncodeAs mete v en un sobre de la versión actual con la codificación dada.
+func (r *Registry) EncodeAs(encoding Encoding, v interface{}, sequence uint32, tick uint64) (string, error) {
+	if encoding == EncodingBinary {
+		return r.encodeBinary(v, sequence, tick)
+	}
+	name, ok := r.Name(v)
+	if !ok {
+		return "", fmt.Errorf("protocol: type %T not registered", v)
+	}
+	codec, _ := r.codec(name)
+	payload, err := codec.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	env := Envelope{
+		Version:  Version,
+		Type:     name,
+		Sequence: sequence,
+		Tick:     tick,
+		Payload:  payload,
+	}
+	if entity, ok := entityOf(v); ok {
+		env.Entity = entity.String()
+	}
+	data, err := json.Marshal(env)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// entityOf devuelve la entidad de un mensaje, si la tiene.
+func entityOf(v interface{}) (uuid.UUID, bool) {
+	entity, ok := v.(interface{ EntityID() uuid.UUID })
+	if !ok {
+		return uuid.Nil, false
+	}
+	return entity.EntityID(), true
+}
+
+// encodeBinary escribe el sobre binario: marca, versión, tipo, secuencia,
+// tick, entidad y la carga, compacta si el tipo tiene BinaryCodec y JSON si
+// no.
+func (r *Registry) encodeBinary(v interface{}, sequence uint32, tick uint64) (string, error) {
+	name, ok := r.Name(v)
+	if !ok {
+		return "", fmt.Errorf("protocol: type %T not registered", v)
+	}
+	w := &Writer{}
+	w.Byte(binaryMagic)
+	w.Uvarint(Version)
+	w.String(name)
+	w.Uvarint(uint64(sequence))
+	w.Uvarint(tick)
+	entity, ok := entityOf(v)
+	w.Bool(ok)
+	if ok {
+		w.UUID(entity)
+	}
+	if codec, ok := r.binaryCodec(name); ok {
+		w.Byte(payloadBinary)
+		if err := codec.MarshalBinary(v, w); err != nil {
+			return "", err
+		}
+	} else {
+		codec, _ := r.codec(name)
+		payload, err := codec.Marshal(v)
+		if err != nil {
+			return "", err
+		}
+		w.Byte(payloadJSON)
+		w.Raw(payload)
+	}
+	return string(w.Bytes()), nil
+}
+
+// readHeader lee la cabecera de un sobre binario. Los de la versión 1 no
+// llevan entidad.
+func readHeader(rd *Reader) Envelope {
+	env := Envelope{Encoding: EncodingBinary}
+	env.Version = int(rd.Uvarint())
+	env.Type = rd.String()
+	env.Sequence = uint32(rd.Uvarint())
+	env.Tick = rd.Uvarint()
+	if env.Version >= 2 && rd.Bool() {
+		env.Entity = rd.UUID().String()
+	}
+	return env
+}
+
+func (r *Registry) decodeBinary(message string) (Envelope, interface{}, error) {
+	rd := NewReader([]byte(message[1:]))
+	env := readHeader(rd)
+	kind := rd.Byte()
+	if err := rd.Err(); err != nil {
+		return env, nil, fmt.Errorf("protocol: invalid envelope: %w", err)
+	}
+	if env.Type == "" {
+		return env, nil, fmt.Errorf("protocol: message without type")
+	}
+	var v interface{}
+	var err error
+	switch kind {
+	case payloadBinary:
+		codec, ok := r.binaryCodec(env.Type)
+		if !ok {
+			return env, nil, fmt.Errorf("protocol: unknown message type %q", env.Type)
+		}
+		v, err = codec.UnmarshalBinary(rd)
+		if err == nil {
+			err = rd.Err()
+		}
+	case payloadJSON:
+		codec, ok := r.codec(env.Type)
+		if !ok {
+			return env, nil, fmt.Errorf("protocol: unknown message type %q", env.Type)
+		}
+		env.Payload = rd.Rest()
+		v, err = codec.Unmarshal(env.Payload)
+	default:
+		err = fmt.Errorf("unknown payload kind %d", kind)
+	}
+	if err != nil {
+		return env, nil, fmt.Errorf("protocol: cannot decode %s: %w", env.Type, err)
+	}
+	return env, v, nil
+}
+
+// Decode abre un sobre, JSON o binario, y decodifica su carga con el códec
+// de su tipo. Devuelve un puntero al tipo registrado.
+func (r *Registry) Decode(message string) (Envelope, interface{}, error) {
+	if IsBinary(message) {
+		return r.decodeBinary(message)
+	}
+	var env Envelope
+	if err := json.Unmarshal([]byte(message), &env); err != nil {
+		return env, nil, fmt.Errorf("protocol: invalid envelope: %w", err)
+	}
+	if env.Type == "" {
+		return env, nil, fmt.Errorf("protocol: message without type")
+	}
+	codec, ok := r.codec(env.Type)
+	if !ok {
+		return env, nil, fmt.Errorf("protocol: unknown message type %q", env.Type)
+	}
+	v, err := codec.Unmarshal(env.Payload)
+	if err != nil {
+		return env, nil, fmt.Errorf("protocol: cannot decode %s: %w", env.Type, err)
+	}
+	return env, v, nil
+}
+
+// Peek lee solo la cabecera de un sobre, sin decodificar la carga, para
+// poder encaminar un mensaje sin pagar su decodificación.
+func Peek(message string) (Envelope, error) {
+	var env Envelope
+	if IsBinary(message) {
+		rd := NewReader([]byte(message[1:]))
+		env = readHeader(rd)
+		if err := rd.Err(); err != nil {
+			return env, fmt.Errorf("protocol: invalid envelope: %w", err)
+		}
+	} else if err := json.Unmarshal([]byte(message), &env); err != nil {
+		return env, fmt.Errorf("protocol: invalid envelope: %w", err)
+	}
+	if env.Type == "" {
+		return env, fmt.Errorf("protocol: message without type")
+	}
+	return env, nil
+}
+
+// Encode usa DefaultRegistry.
+func Encode(v interface{}, sequence uint32, tick uint64) (string, error) {
+	return DefaultRegistry.Encode(v, sequence, tick)
+}
+
+// EncodeAs usa DefaultRegistry.
+func EncodeAs(encoding Encoding, v interface{}, sequence uint32, tick uint64) (string, error) {
+	return DefaultRegistry.EncodeAs(encoding, v, sequence, tick)
+}
+
+// Decode usa DefaultRegistry.
+func Decode(message string) (Envelope, interface{}, error) {
+	return DefaultRegistry.Decode(message)
+}
@@ -1,41 +1,38 @@
 package game
 
 import (
-	"encoding/json"
 	"fmt"
 	"image"
 	"image/color"
-	"math"
-	"time"
 
 	"github.com/hajimehoshi/ebiten/v2"
 	"github.com/hajimehoshi/ebiten/v2/text"
 
 	"github.com/demonodojo/rabbits/assets"
+	"github.com/demonodojo/rabbits/game/sim"
 	"github.com/google/uuid"
 )
 
+// Rabbit es la parte visible de un sim.Rabbit: el estado y la lógica están en
+// la simulación y aquí solo se guarda lo necesario para dibujarlo.
 type Rabbit struct {
-	Serial
-	game           *Game
-	Position       Vector  `json:"position"`
-	Rotation       float64 `json:"rotation"`
-	Speed          float64 `json:"speed"`
-	Score          int32   `json:"score"`
-	Heat           int64   `json:"heat"`
-	Load           int64   `json:"load"`
-	lastUpdateTime time.Time
-	scale          float64
-	bounds         image.Rectangle
-	halfW          float64
-	halfH          float64
-	sprite         *ebiten.Image
-	spriteR        *ebiten.Image
+	*sim.Rabbit
+	game    *Game
+	scale   float64
+	bounds  image.Rectangle
+	halfW   float64
+	halfH   float64
+	sprite  *ebiten.Image
+	spriteR *ebiten.Image
 
 	shootCooldown *Timer
 }
 
 func NewRabbit(game *Game) *Rabbit {
+	return WrapRabbit(game, sim.NewRabbit(uuid.New()))
+}
+
+func WrapRabbit(game *Game, state *sim.Rabbit) *Rabbit {
 	sprite := assets.PlayerSprite
 	spriteR := assets.RabbitSpriteR
 
@@ -44,97 +41,27 @@ func NewRabbit(game *Game) *Rabbit {
 	halfW := float64(bounds.Dx()) * scale / 2
 	halfH := float64(bounds.Dy()) * scale / 2
 
-	pos := Vector{
-		X: screenWidth/2 - halfW,
-		Y: screenHeight/2 - halfH,
-	}
-
 	return &Rabbit{
-		Serial: Serial{
-			ID:        uuid.New(),
-			ClassName: "Rabbit",
-			Action:    "Spawn",
-		},
-		game:           game,
-		Position:       pos,
-		Score:          0,
-		Heat:           0,
-		Load:           0,
-		scale:          scale,
-		bounds:         bounds,
-		halfW:          halfW,
-		halfH:          halfH,
-		Rotation:       0,
-		sprite:         sprite,
-		spriteR:        spriteR,
-		lastUpdateTime: time.Now(),
+		Rabbit:  state,
+		game:    game,
+		scale:   scale,
+		bounds:  bounds,
+		halfW:   halfW,
+		halfH:   halfH,
+		sprite:  sprite,
+		spriteR: spriteR,
 	}
 }
 
 func (r *Rabbit) Update() {
-	now := time.Now()
-	delta := now.Sub(r.lastUpdateTime)
-	r.lastUpdateTime = now
-
-	deltaMs := delta.Seconds() * 1000
-	updateFactor := deltaMs / 16.666
-	r.Position.X += math.Sin(r.Rotation) * updateFactor * r.Speed
-	r.Position.Y += math.Cos(r.Rotation) * updateFactor * (-r.Speed)
-	if r.Heat > 0 {
-		r.Heat--
-	}
-	if r.Load > 0 {
-		r.Load--
-	}
+	r.Step()
 }
 
 func (r *Rabbit) Interact() bool {
-	rotationSpeed := rotationPerSecond / float64(ebiten.TPS())
-	SpeedPerSecond := 0.1
-	interaction := false
-	if ebiten.IsKeyPressed(ebiten.KeyLeft) {
-		r.Rotation -= rotationSpeed
-		interaction = true
-	}
-	if ebiten.IsKeyPressed(ebiten.KeyRight) {
-		r.Rotation += rotationSpeed
-		interaction = true
-	}
-
-	if ebiten.IsKeyPressed(ebiten.KeyUp) {
-		if r.Speed < 5 {
-			r.Speed += SpeedPerSecond
-			interaction = true
-		}
-	}
-
-	if ebiten.IsKeyPressed(ebiten.KeyDown) {
-		if r.Speed > -5 {
-			r.Speed -= SpeedPerSecond
-			interaction = true
-		}
-	}
-
-	if ebiten.IsKeyPressed(ebiten.KeyF) {
-		if r.Heat < 100 && r.Load == 0 {
-			r.Action = "FIRE"
-			r.Load = 30
-			r.Heat += 30
-			interaction = true
-		}
-	}
+	in := ReadInput(r.ID, r.LastInput+1)
+	r.Simulate(in)
 
-	r.Update()
-
-	return interaction
-}
-
-func (r *Rabbit) advancedPosition() (Vector, float64) {
-	position := Vector{
-		X: r.Position.X + 19.0 + math.Sin(r.Rotation)*40,
-		Y: r.Position.Y + 15.0 - math.Cos(r.Rotation)*40,
-	}
-	return position, r.Rotation
+	return in.Active()
 }
 
 func (r *Rabbit) Draw(screen *ebiten.Image, geom ebiten.GeoM) {
@@ -152,35 +79,10 @@ func (r *Rabbit) Draw(screen *ebiten.Image, geom ebiten.GeoM) {
 	op.GeoM.Concat(geom)
 
 	screen.DrawImage(r.sprite, op)
+	if r.Name != "" {
+		nx, ny := geom.Apply(x, y)
+		text.Draw(screen, r.Name, assets.InfoFont, int(nx), int(ny)-5, color.White)
+	}
 	text.Draw(screen, fmt.Sprintf("%f %f", r.halfH, r.halfW), assets.InfoFont, 10, 70, color.White)
 	text.Draw(screen, fmt.Sprintf("Speed %f", r.Speed), assets.InfoFont, 10, 90, color.White)
 }
-
-func (r *Rabbit) Fired() {
-	r.Speed = 0
-	r.Score -= 1
-}
-
-func (r *Rabbit) Collider() Rect {
-
-	return NewRect(
-		r.Position.X,
-		r.Position.Y,
-		float64(r.halfW*2),
-		float64(r.halfH*2),
-	)
-}
-
-func (r *Rabbit) ToJson() string {
-	json, _ := json.Marshal(r)
-	return string(json)
-}
-
-func (r *Rabbit) CopyFrom(other *Rabbit) {
-	r.ID = other.ID
-	r.Action = other.Action
-	r.Position = other.Position
-	r.Rotation = other.Rotation
-	r.Speed = other.Speed
-	r.Score = other.Score
-}
@@ -5,11 +5,14 @@ import (
 	"image/color"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/hajimehoshi/ebiten/v2"
 	"github.com/hajimehoshi/ebiten/v2/text"
 	"golang.org/x/image/math/f64"
 
 	"github.com/demonodojo/rabbits/assets"
+	"github.com/demonodojo/rabbits/game/replay"
+	"github.com/demonodojo/rabbits/game/sim"
 )
 
 const (
@@ -24,14 +27,16 @@ const (
 )
 
 type RabbitDirectScene struct {
-	game              *Game
-	camera            *Camera
-	offscreen         *ebiten.Image
-	player            *Player
-	rabbit            *Rabbit
-	lettuceSpawnTimer *Timer
-	lettuces          []*Lettuce
-	bullets           []*Bullet
+	game          *Game
+	camera        *Camera
+	offscreen     *ebiten.Image
+	player        *Player
+	world         *sim.World
+	rabbit        *Rabbit
+	lettuces      map[uuid.UUID]*Lettuce
+	bullets       map[uuid.UUID]*Bullet
+	inputSequence uint32
+	recorder      *replay.Recorder
 
 	score         int
 	scale         float64
@@ -41,25 +46,35 @@ type RabbitDirectScene struct {
 
 func NewRabbitDirectScene(g *Game) *RabbitDirectScene {
 	s := &RabbitDirectScene{
-		game:              g,
-		camera:            &Camera{ViewPort: f64.Vec2{screenWidth, screenHeight}},
-		lettuceSpawnTimer: NewTimer(lettuceSpawnTime),
-		baseVelocity:      baseMeteorVelocity,
-		velocityTimer:     NewTimer(meteorSpeedUpTime),
+		game:          g,
+		camera:        &Camera{ViewPort: f64.Vec2{screenWidth, screenHeight}},
+		baseVelocity:  baseMeteorVelocity,
+		velocityTimer: NewTimer(meteorSpeedUpTime),
 	}
 
 	s.camera.Reset()
-	s.rabbit = NewRabbit(g)
-
-	m := NewLettuce()
-	s.lettuces = append(s.lettuces, m)
+	s.Reset()
 
 	return s
 }
 
+// Record graba la partida con recorder: cada comando y el mundo de cada tick.
+// Cerrar el Recorder es cosa de quien lo crea.
+func (g *RabbitDirectScene) Record(recorder *replay.Recorder) {
+	g.recorder = recorder
+}
+
 func (g *RabbitDirectScene) Update() error {
 
-	g.rabbit.Interact()
+	g.inputSequence++
+	in := ReadInput(g.rabbit.ID, g.inputSequence)
+	if g.recorder != nil {
+		g.recorder.RecordInput(g.world.Tick, in)
+	}
+	events := g.world.Step(map[uuid.UUID]Input{g.rabbit.ID: in})
+	if g.recorder != nil {
+		g.recorder.RecordSnapshot(g.world.Snapshot())
+	}
 
 	g.scale += 0.01
 	if g.scale > 2 {
@@ -72,20 +87,6 @@ func (g *RabbitDirectScene) Update() error {
 		g.baseVelocity += meteorSpeedUpAmount
 	}
 
-	g.rabbit.Update()
-
-	g.lettuceSpawnTimer.Update()
-	if g.lettuceSpawnTimer.IsReady() {
-		g.lettuceSpawnTimer.Reset()
-
-		m := NewLettuce()
-		g.lettuces = append(g.lettuces, m)
-	}
-
-	for _, l := range g.lettuces {
-		l.Update()
-	}
-
 	if ebiten.IsKeyPressed(ebiten.Key1) {
 		ebiten.SetFullscreen(true)
 	}
@@ -96,34 +97,24 @@ func (g *RabbitDirectScene) Update() error {
 
 	g.camera.Update(g.rabbit)
 
-	// for _, b := range g.bullets {
-	// 	b.Update()
-	// }
-
-	// // Check for meteor/bullet collisions
-	// for i, m := range g.meteors {
-	// 	for j, b := range g.bullets {
-	// 		if m.Collider().Intersects(b.Collider()) {
-	// 			g.meteors = append(g.meteors[:i], g.meteors[i+1:]...)
-	// 			g.bullets = append(g.bullets[:j], g.bullets[j+1:]...)
-	// 			g.score++
-	// 		}
-	// 	}
-	// }
-
-	// Check for rabbit/lettuces collisions
-	for i, l := range g.lettuces {
-		if l.Collider().Intersects(g.rabbit.Collider()) {
-			g.lettuces = append(g.lettuces[:i], g.lettuces[i+1:]...)
+	for _, e := range events {
+		switch e.Kind {
+		case sim.EventBulletSpawned:
+			g.bullets[e.Bullet.ID] = WrapBullet(e.Bullet)
+		case sim.EventBulletRemoved:
+			delete(g.bullets, e.Bullet.ID)
+		case sim.EventLettuceSpawned:
+			g.lettuces[e.Lettuce.ID] = WrapLettuce(e.Lettuce)
+		case sim.EventLettuceEaten:
+			delete(g.lettuces, e.Lettuce.ID)
 			g.score++
-			if len(g.lettuces) == 0 {
-				g.Reset()
-			}
-			//g.Reset()
-			break
 		}
 	}
 
+	if g.score > 0 && len(g.lettuces) == 0 {
+		g.Reset()
+	}
+
 	return nil
 }
 
@@ -136,23 +127,30 @@ func (g *RabbitDirectScene) Draw(screen *ebiten.Image) {
 
 	g.rabbit.Draw(screen, g.camera.Matrix)
 
-	for _, l := range g.lettuces {
-		l.Draw(screen, g.camera.Matrix)
+	for _, id := range GetOrderedIds(g.lettuces) {
+		g.lettuces[id].Draw(screen, g.camera.Matrix)
 	}
 
-	// for _, b := range g.bullets {
-	// 	b.Draw(screen)
-	// }
+	for _, id := range GetOrderedIds(g.bullets) {
+		g.bullets[id].Draw(screen, g.camera.Matrix)
+	}
 
 	text.Draw(screen, fmt.Sprintf("%06d", g.score), assets.ScoreFont, screenWidth/2-100, 50, color.White)
 }
 
 func (g *RabbitDirectScene) Reset() {
+	if g.recorder != nil {
+		g.recorder.Restart()
+	}
+	g.world = sim.NewWorld(time.Now().UnixNano())
 	g.rabbit = NewRabbit(g.game)
-	g.lettuces = nil
-	g.bullets = nil
+	g.world.AddRabbit(g.rabbit.Rabbit)
+	g.lettuces = make(map[uuid.UUID]*Lettuce)
+	g.bullets = make(map[uuid.UUID]*Bullet)
+	l := g.world.SpawnLettuce()
+	g.lettuces[l.ID] = WrapLettuce(l)
 	g.score = 0
-	g.lettuceSpawnTimer.Reset()
+	g.inputSequence = 0
 	g.baseVelocity = baseMeteorVelocity
 	g.velocityTimer.Reset()
 }
@@ -1,32 +1,11 @@
 package game
 
-type Rect struct {
-	X      float64
-	Y      float64
-	Width  float64
-	Height float64
-}
-
-func NewRect(x, y, width, height float64) Rect {
-	return Rect{
-		X:      x,
-		Y:      y,
-		Width:  width,
-		Height: height,
-	}
-}
+import (
+	"github.com/demonodojo/rabbits/game/sim"
+)
 
-func (r Rect) MaxX() float64 {
-	return r.X + r.Width
-}
-
-func (r Rect) MaxY() float64 {
-	return r.Y + r.Height
-}
+type Rect = sim.Rect
 
-func (r Rect) Intersects(other Rect) bool {
-	return r.X <= other.MaxX() &&
-		other.X <= r.MaxX() &&
-		r.Y <= other.MaxY() &&
-		other.Y <= r.MaxY()
+func NewRect(x, y, width, height float64) Rect {
+	return sim.NewRect(x, y, width, height)
 }
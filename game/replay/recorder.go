@@ -0,0 +1,173 @@
+// Package replay graba partidas en un fichero compacto y las reproduce. No
+// depende de Ebiten, así que también graba desde el servidor sin ventana.
+package replay
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/demonodojo/rabbits/game/protocol"
+	"github.com/demonodojo/rabbits/game/sim"
+)
+
+const (
+	// DefaultKeyframeInterval es cada cuántos ticks se guarda el mundo
+	// completo en lugar de un delta, para poder saltar a cualquier punto.
+	DefaultKeyframeInterval = 5 * sim.TickRate
+
+	version = 1
+)
+
+var magic = []byte("RBRP")
+
+// Tipos de registro del fichero.
+const (
+	recordInput byte = iota + 1
+	recordState
+)
+
+// ErrClosed lo devuelve Recorder tras cerrarse.
+var ErrClosed = errors.New("replay: recorder closed")
+
+// Recorder escribe una grabación. Cada registro lleva el tick en el que
+// ocurrió y un sobre binario del protocolo; el estado se guarda como delta
+// contra el anterior, con un fotograma completo cada KeyframeInterval ticks,
+// y todo va comprimido con gzip. Se puede usar desde varias goroutines.
+type Recorder struct {
+	// KeyframeInterval es cada cuántos ticks se guarda el mundo completo.
+	KeyframeInterval uint64
+
+	mutex    sync.Mutex
+	file     io.WriteCloser
+	gz       *gzip.Writer
+	w        *bufio.Writer
+	last     *sim.Snapshot
+	keyframe uint64
+	tick     uint64 // último tick grabado, ya desplazado
+	offset   uint64 // se suma a los ticks desde el último Restart
+	err      error
+}
+
+// Create crea el fichero path y empieza a grabar en él.
+func Create(path string) (*Recorder, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	r, err := NewRecorder(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// NewRecorder graba en w, que se cierra con el Recorder.
+func NewRecorder(w io.WriteCloser) (*Recorder, error) {
+	gz := gzip.NewWriter(w)
+	r := &Recorder{
+		KeyframeInterval: DefaultKeyframeInterval,
+		file:             w,
+		gz:               gz,
+		w:                bufio.NewWriter(gz),
+	}
+	r.w.Write(magic)
+	r.w.WriteByte(version)
+	if err := r.w.Flush(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// RecordInput apunta un comando recibido en el tick dado.
+func (r *Recorder) RecordInput(tick uint64, in sim.Input) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.write(recordInput, tick+r.offset, &in)
+}
+
+// RecordSnapshot apunta el estado del mundo. Los ticks deben ir en aumento.
+func (r *Recorder) RecordSnapshot(snapshot *sim.Snapshot) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	shifted := *snapshot
+	shifted.Tick += r.offset
+	snapshot = &shifted
+	if r.last != nil && snapshot.Tick <= r.last.Tick {
+		return
+	}
+	base := r.last
+	if base == nil || snapshot.Tick-r.keyframe >= r.KeyframeInterval {
+		base = nil
+		r.keyframe = snapshot.Tick
+	}
+	r.write(recordState, snapshot.Tick, snapshot.Diff(base))
+	r.last = snapshot
+}
+
+func (r *Recorder) write(kind byte, tick uint64, v interface{}) {
+	if r.err != nil {
+		return
+	}
+	r.tick = max(r.tick, tick)
+	message, err := protocol.EncodeAs(protocol.EncodingBinary, v, 0, tick)
+	if err != nil {
+		r.err = err
+		return
+	}
+	header := make([]byte, 0, 1+2*binary.MaxVarintLen64)
+	header = append(header, kind)
+	header = binary.AppendUvarint(header, tick)
+	header = binary.AppendUvarint(header, uint64(len(message)))
+	if _, err := r.w.Write(header); err != nil {
+		r.err = err
+		return
+	}
+	if _, err := r.w.WriteString(message); err != nil {
+		r.err = err
+	}
+}
+
+// Restart avisa de que la partida ha vuelto a empezar desde el tick cero. Lo
+// que se grabe después va a continuación de lo anterior y empieza con un
+// fotograma completo.
+func (r *Recorder) Restart() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.offset = r.tick + 1
+	r.last = nil
+}
+
+// Err devuelve el primer error de escritura, si lo ha habido. A partir de
+// ese error no se graba nada más.
+func (r *Recorder) Err() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.err
+}
+
+// Close vacía lo pendiente y cierra el fichero.
+func (r *Recorder) Close() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.err == ErrClosed {
+		return nil
+	}
+	err := r.err
+	if e := r.w.Flush(); err == nil {
+		err = e
+	}
+	if e := r.gz.Close(); err == nil {
+		err = e
+	}
+	if e := r.file.Close(); err == nil {
+		err = e
+	}
+	r.err = ErrClosed
+	return err
+}
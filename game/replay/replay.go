@@ -0,0 +1,190 @@
+package replay
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/google/uuid"
+
+	"github.com/demonodojo/rabbits/game/protocol"
+	"github.com/demonodojo/rabbits/game/sim"
+)
+
+// maxRecordSize acota un registro para que un fichero corrupto no reserve
+// memoria sin fin.
+const maxRecordSize = 16 << 20
+
+// InputRecord es un comando grabado con el tick en que llegó.
+type InputRecord struct {
+	Tick  uint64
+	Input sim.Input
+}
+
+// Replay es una grabación cargada en memoria. Guarda los deltas tal cual y
+// reconstruye el mundo de un tick a partir del fotograma completo anterior.
+type Replay struct {
+	states []*sim.SnapshotDelta
+	inputs map[uuid.UUID][]InputRecord
+
+	// últimos mundos reconstruidos, para que la reproducción en orden no
+	// tenga que volver al fotograma completo en cada tick
+	cache []cachedSnapshot
+}
+
+type cachedSnapshot struct {
+	index    int
+	snapshot *sim.Snapshot
+}
+
+const cacheSize = 2
+
+// Open carga la grabación del fichero path.
+func Open(path string) (*Replay, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Read(f)
+}
+
+// Read carga una grabación escrita por Recorder. Una grabación cortada a
+// medias, por ejemplo porque el servidor se cerró de golpe, se carga hasta
+// el último registro completo.
+func Read(r io.Reader) (*Replay, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("replay: %w", err)
+	}
+	br := bufio.NewReader(gz)
+
+	header := make([]byte, len(magic)+1)
+	if _, err := io.ReadFull(br, header); err != nil {
+		return nil, fmt.Errorf("replay: %w", err)
+	}
+	if !bytes.Equal(header[:len(magic)], magic) {
+		return nil, fmt.Errorf("replay: not a replay file")
+	}
+	if header[len(magic)] != version {
+		return nil, fmt.Errorf("replay: unsupported version %d", header[len(magic)])
+	}
+
+	rep := &Replay{inputs: make(map[uuid.UUID][]InputRecord)}
+	for {
+		kind, err := br.ReadByte()
+		if err == io.EOF {
+			break
+		}
+		tick, err1 := binary.ReadUvarint(br)
+		size, err2 := binary.ReadUvarint(br)
+		if err != nil || err1 != nil || err2 != nil || size > maxRecordSize {
+			break
+		}
+		if kind != recordInput && kind != recordState {
+			return nil, fmt.Errorf("replay: unknown record %d", kind)
+		}
+		payload := make([]byte, size)
+		if _, err := io.ReadFull(br, payload); err != nil {
+			break
+		}
+		_, value, err := protocol.Decode(string(payload))
+		if err != nil {
+			return nil, fmt.Errorf("replay: record at tick %d: %w", tick, err)
+		}
+		switch v := value.(type) {
+		case *sim.Input:
+			rep.inputs[v.ID] = append(rep.inputs[v.ID], InputRecord{Tick: tick, Input: *v})
+		case *sim.SnapshotDelta:
+			if len(rep.states) == 0 && !v.Full {
+				return nil, fmt.Errorf("replay: first state at tick %d is not a keyframe", tick)
+			}
+			rep.states = append(rep.states, v)
+		}
+	}
+	if len(rep.states) == 0 {
+		return nil, fmt.Errorf("replay: no recorded state")
+	}
+	return rep, nil
+}
+
+// First y Last son el primer y el último tick con estado grabado.
+func (r *Replay) First() uint64 {
+	return r.states[0].Tick
+}
+
+func (r *Replay) Last() uint64 {
+	return r.states[len(r.states)-1].Tick
+}
+
+// index es la posición del último estado grabado en tick o antes.
+func (r *Replay) index(tick uint64) int {
+	i := sort.Search(len(r.states), func(i int) bool { return r.states[i].Tick > tick })
+	return max(i-1, 0)
+}
+
+// Snapshot devuelve el mundo tal como estaba en tick: el último estado
+// grabado en ese tick o antes.
+func (r *Replay) Snapshot(tick uint64) *sim.Snapshot {
+	return r.snapshotAt(r.index(tick))
+}
+
+// Frames devuelve los dos estados grabados entre los que cae tick, para
+// interpolar. Al final de la grabación los dos son el mismo.
+func (r *Replay) Frames(tick uint64) (from, to *sim.Snapshot) {
+	i := r.index(tick)
+	from = r.snapshotAt(i)
+	if i+1 < len(r.states) {
+		return from, r.snapshotAt(i + 1)
+	}
+	return from, from
+}
+
+func (r *Replay) snapshotAt(i int) *sim.Snapshot {
+	start := i
+	for !r.states[start].Full {
+		start--
+	}
+	var snapshot *sim.Snapshot
+	for _, c := range r.cache {
+		if c.index == i {
+			return c.snapshot
+		}
+		if c.index >= start && c.index < i {
+			// Seguimos desde la caché en lugar de desde el fotograma completo
+			start, snapshot = c.index+1, c.snapshot
+		}
+	}
+	for j := start; j <= i; j++ {
+		snapshot = r.states[j].Apply(snapshot)
+	}
+	r.cache = append(r.cache, cachedSnapshot{i, snapshot})
+	if len(r.cache) > cacheSize {
+		r.cache = r.cache[1:]
+	}
+	return snapshot
+}
+
+// Input devuelve el último comando del conejo id recibido en tick o antes.
+func (r *Replay) Input(id uuid.UUID, tick uint64) (sim.Input, bool) {
+	records := r.inputs[id]
+	i := sort.Search(len(records), func(i int) bool { return records[i].Tick > tick })
+	if i == 0 {
+		return sim.Input{}, false
+	}
+	return records[i-1].Input, true
+}
+
+// Inputs devuelve cuántos comandos hay grabados.
+func (r *Replay) Inputs() int {
+	n := 0
+	for _, records := range r.inputs {
+		n += len(records)
+	}
+	return n
+}
@@ -0,0 +1,251 @@
+package game
+
+import (
+	"fmt"
+	"image/color"
+	"math"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
+	"github.com/hajimehoshi/ebiten/v2/text"
+	"golang.org/x/image/math/f64"
+
+	"github.com/demonodojo/rabbits/assets"
+	"github.com/demonodojo/rabbits/game/replay"
+	"github.com/demonodojo/rabbits/game/sim"
+)
+
+const (
+	replaySeekStep = 5 * sim.TickRate
+	replayMinSpeed = 0.25
+	replayMaxSpeed = 8
+)
+
+// ReplayScene reproduce una grabación. Enter pausa, las flechas izquierda y
+// derecha saltan 5 segundos, arriba y abajo cambian la velocidad, Inicio
+// vuelve al principio y Tab elige a qué conejo sigue la cámara, que se
+// maneja como en las demás escenas.
+type ReplayScene struct {
+	game   *Game
+	camera *Camera
+	replay *replay.Replay
+
+	tick   float64 // instante que se dibuja, en ticks de la grabación
+	speed  float64
+	paused bool
+
+	rabbits  map[uuid.UUID]*Rabbit
+	bullets  map[uuid.UUID]*Bullet
+	lettuces map[uuid.UUID]*Lettuce
+	follow   uuid.UUID // conejo al que sigue la cámara con O
+	free     *Rabbit   // lo que sigue la cámara si no hay conejo
+}
+
+func NewReplayScene(g *Game, rep *replay.Replay) *ReplayScene {
+	s := &ReplayScene{
+		game:     g,
+		camera:   &Camera{ViewPort: f64.Vec2{screenWidth, screenHeight}},
+		replay:   rep,
+		tick:     float64(rep.First()),
+		speed:    1,
+		rabbits:  make(map[uuid.UUID]*Rabbit),
+		bullets:  make(map[uuid.UUID]*Bullet),
+		lettuces: make(map[uuid.UUID]*Lettuce),
+		free:     NewRabbit(g),
+	}
+	s.camera.Reset()
+	s.show()
+	return s
+}
+
+func (s *ReplayScene) Update() error {
+	if inpututil.IsKeyJustPressed(ebiten.KeyEnter) {
+		s.paused = !s.paused
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyLeft) {
+		s.Seek(s.tick - replaySeekStep)
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyRight) {
+		s.Seek(s.tick + replaySeekStep)
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyHome) {
+		s.Seek(float64(s.replay.First()))
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyUp) {
+		s.speed = math.Min(s.speed*2, replayMaxSpeed)
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyDown) {
+		s.speed = math.Max(s.speed/2, replayMinSpeed)
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyTab) {
+		s.followNext()
+	}
+
+	if !s.paused {
+		s.Seek(s.tick + s.speed)
+	}
+	s.show()
+
+	target := s.free
+	if r := s.rabbits[s.follow]; r != nil {
+		target = r
+	}
+	s.camera.Update(target)
+
+	if ebiten.IsKeyPressed(ebiten.Key1) {
+		ebiten.SetFullscreen(true)
+	}
+	if ebiten.IsKeyPressed(ebiten.Key2) {
+		ebiten.SetFullscreen(false)
+	}
+	return nil
+}
+
+// Seek salta al tick dado, dentro de lo grabado. Al llegar al final se
+// pausa.
+func (s *ReplayScene) Seek(tick float64) {
+	first, last := float64(s.replay.First()), float64(s.replay.Last())
+	s.tick = math.Max(first, math.Min(tick, last))
+	if s.tick == last {
+		s.paused = true
+	}
+}
+
+// show deja las entidades como en s.tick, interpolando entre los dos estados
+// grabados más cercanos.
+func (s *ReplayScene) show() {
+	from, to := s.replay.Frames(uint64(s.tick))
+	t := 0.0
+	if to.Tick > from.Tick {
+		t = (s.tick - float64(from.Tick)) / float64(to.Tick-from.Tick)
+	}
+
+	for id, state := range from.Rabbits {
+		r := s.rabbits[id]
+		if r == nil {
+			r = WrapRabbit(s.game, &sim.Rabbit{})
+			s.rabbits[id] = r
+		}
+		r.CopyFrom(&state)
+		if next, ok := to.Rabbits[id]; ok {
+			r.Position = lerpVector(state.Position, next.Position, t)
+			r.Rotation = lerpAngle(state.Rotation, next.Rotation, t)
+		}
+	}
+	for id := range s.rabbits {
+		if _, ok := from.Rabbits[id]; !ok {
+			delete(s.rabbits, id)
+		}
+	}
+
+	for id, state := range from.Bullets {
+		b := s.bullets[id]
+		if b == nil {
+			b = WrapBullet(&sim.Bullet{})
+			s.bullets[id] = b
+		}
+		b.CopyFrom(&state)
+		if next, ok := to.Bullets[id]; ok {
+			b.Position = lerpVector(state.Position, next.Position, t)
+		}
+	}
+	for id := range s.bullets {
+		if _, ok := from.Bullets[id]; !ok {
+			delete(s.bullets, id)
+		}
+	}
+
+	for id, state := range from.Lettuces {
+		l := s.lettuces[id]
+		if l == nil {
+			l = WrapLettuce(&sim.Lettuce{})
+			s.lettuces[id] = l
+		}
+		l.CopyFrom(&state)
+	}
+	for id := range s.lettuces {
+		if _, ok := from.Lettuces[id]; !ok {
+			delete(s.lettuces, id)
+		}
+	}
+}
+
+// followNext pasa la cámara al siguiente conejo, en orden de ID.
+func (s *ReplayScene) followNext() {
+	ids := GetOrderedIds(s.rabbits)
+	if len(ids) == 0 {
+		return
+	}
+	next := ids[0]
+	for i, id := range ids {
+		if id == s.follow && i+1 < len(ids) {
+			next = ids[i+1]
+		}
+	}
+	s.follow = next
+}
+
+func lerpVector(from, to Vector, t float64) Vector {
+	return Vector{
+		X: from.X + (to.X-from.X)*t,
+		Y: from.Y + (to.Y-from.Y)*t,
+	}
+}
+
+func (s *ReplayScene) Draw(screen *ebiten.Image) {
+	for _, id := range GetOrderedIds(s.lettuces) {
+		s.lettuces[id].Draw(screen, s.camera.Matrix)
+	}
+	for _, id := range GetOrderedIds(s.rabbits) {
+		s.rabbits[id].Draw(screen, s.camera.Matrix)
+	}
+	for _, id := range GetOrderedIds(s.bullets) {
+		s.bullets[id].Draw(screen, s.camera.Matrix)
+	}
+
+	elapsed := time.Duration(s.tick-float64(s.replay.First())) * sim.TickDuration
+	total := time.Duration(s.replay.Last()-s.replay.First()) * sim.TickDuration
+	state := fmt.Sprintf("x%g", s.speed)
+	if s.paused {
+		state = "PAUSA"
+	}
+	status := fmt.Sprintf("%s / %s  %s", elapsed.Truncate(time.Second), total.Truncate(time.Second), state)
+	text.Draw(screen, status, assets.InfoFont, 10, 50, color.White)
+
+	if r := s.rabbits[s.follow]; r != nil {
+		info := fmt.Sprintf("%s  %06d", r.Name, r.Score)
+		if in, ok := s.replay.Input(r.ID, uint64(s.tick)); ok {
+			info += "  " + buttons(in)
+		}
+		text.Draw(screen, info, assets.InfoFont, 10, screenHeight-20, color.White)
+	}
+}
+
+// buttons resume los botones pulsados en un comando.
+func buttons(in sim.Input) string {
+	pressed := []struct {
+		on   bool
+		name string
+	}{
+		{in.Left, "<"}, {in.Thrust, "^"}, {in.Brake, "v"}, {in.Right, ">"}, {in.Fire, "F"},
+	}
+	s := ""
+	for _, b := range pressed {
+		if b.on {
+			s += b.name
+		} else {
+			s += "."
+		}
+	}
+	return s
+}
+
+func (s *ReplayScene) Layout(outsideWidth, outsideHeight int) (int, int) {
+	return screenWidth, screenHeight
+}
+
+func (s *ReplayScene) SpawnElement(name string, element interface{}) {
+
+}
@@ -0,0 +1,29 @@
+//go:build !js || !wasm
+
+package scenes
+
+import (
+	"github.com/demonodojo/rabbits/game/network"
+)
+
+const (
+	canHostServer = true
+	canQuit       = true
+	canDiscover   = true
+)
+
+func defaultServerURL() string {
+	return "ws://localhost:8080/ws"
+}
+
+func dialServer(url string) (network.GenericClient, error) {
+	client, err := network.NewClient(url)
+	if err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+func discoverServers() ([]network.DiscoveredServer, error) {
+	return network.Discover(network.DefaultDiscoveryPort, network.DefaultDiscoveryTimeout)
+}
@@ -0,0 +1,39 @@
+//go:build js && wasm
+
+package scenes
+
+import (
+	"errors"
+	"syscall/js"
+
+	"github.com/demonodojo/rabbits/game/network"
+)
+
+// En el navegador no se puede abrir un puerto, mandar UDP ni cerrar la
+// ventana.
+const (
+	canHostServer = false
+	canQuit       = false
+	canDiscover   = false
+)
+
+// defaultServerURL apunta al servidor del mismo host que sirve la página.
+func defaultServerURL() string {
+	host := js.Global().Get("location").Get("hostname").String()
+	if host == "" {
+		host = "localhost"
+	}
+	return "ws://" + host + ":8080/ws"
+}
+
+func dialServer(url string) (network.GenericClient, error) {
+	client, err := network.NewJSClient(url)
+	if err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+func discoverServers() ([]network.DiscoveredServer, error) {
+	return nil, errors.New("LAN discovery is not available in the browser")
+}
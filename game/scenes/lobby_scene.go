@@ -0,0 +1,392 @@
+package scenes
+
+import (
+	"fmt"
+	"image/color"
+	"log"
+	"time"
+
+	"github.com/ebitenui/ebitenui"
+	e_image "github.com/ebitenui/ebitenui/image"
+	"github.com/ebitenui/ebitenui/widget"
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
+	"github.com/hajimehoshi/ebiten/v2/text"
+
+	"github.com/demonodojo/rabbits/assets"
+	"github.com/demonodojo/rabbits/game"
+	"github.com/demonodojo/rabbits/game/elements/forms"
+	"github.com/demonodojo/rabbits/game/network"
+	"github.com/demonodojo/rabbits/game/protocol"
+)
+
+const (
+	// roomsRefresh es cada cuánto se vuelve a pedir la lista de salas, para
+	// ver cómo cambian los jugadores y el ping.
+	roomsRefresh = 2 * time.Second
+)
+
+type dialResult struct {
+	client network.GenericClient
+	err    error
+}
+
+type scanResult struct {
+	servers []network.DiscoveredServer
+	err     error
+}
+
+// LobbyScene es el navegador de servidores: busca servidores en la red local o
+// se conecta a la dirección que escriba el jugador, lista las salas con sus
+// jugadores y el ping, y entra en la que elija con ClientScene.
+type LobbyScene struct {
+	game        *game.Game
+	ui          *ebitenui.UI
+	address     *widget.TextInput
+	name        *widget.TextInput
+	team        *widget.TextInput
+	newRoom     *widget.TextInput
+	roomsList   *widget.Container
+	serversList *widget.Container
+
+	client   network.GenericClient
+	dialing  chan dialResult
+	scanning chan scanResult
+	url      string
+	rooms    []protocol.RoomInfo
+	listSent time.Time // cuándo se pidió la lista, cero si ya llegó
+	listed   time.Time // cuándo llegó la última lista
+	ping     time.Duration
+	creating string // sala recién creada en la que entrar en cuanto aparezca
+	watch    bool   // entrar en las salas como espectador
+	status   string
+	back     bool
+}
+
+func NewLobbyScene(g *game.Game) *LobbyScene {
+	s := &LobbyScene{
+		game: g,
+	}
+
+	root := widget.NewContainer(
+		widget.ContainerOpts.BackgroundImage(e_image.NewNineSliceColor(color.NRGBA{0x13, 0x1a, 0x22, 0xff})),
+		widget.ContainerOpts.Layout(widget.NewAnchorLayout()),
+	)
+	column := widget.NewContainer(
+		widget.ContainerOpts.WidgetOpts(
+			widget.WidgetOpts.LayoutData(widget.AnchorLayoutData{
+				HorizontalPosition: widget.AnchorLayoutPositionCenter,
+				VerticalPosition:   widget.AnchorLayoutPositionStart,
+			}),
+			widget.WidgetOpts.MinSize(420, 0),
+		),
+		widget.ContainerOpts.Layout(widget.NewRowLayout(
+			widget.RowLayoutOpts.Direction(widget.DirectionVertical),
+			widget.RowLayoutOpts.Spacing(10),
+			widget.RowLayoutOpts.Padding(widget.Insets{Top: 110, Left: 20, Right: 20, Bottom: 20}))),
+	)
+	root.AddChild(column)
+
+	s.address = forms.NewTextInput("Server address", defaultServerURL())
+	s.name = forms.NewTextInput("Nickname", "")
+	s.team = forms.NewTextInput("Team (optional)", "")
+	column.AddChild(s.address)
+	column.AddChild(s.name)
+	column.AddChild(s.team)
+	column.AddChild(stretch(forms.NewButton("Connect", func(args *widget.ButtonClickedEventArgs) {
+		s.Connect()
+	})))
+	if canDiscover {
+		column.AddChild(stretch(forms.NewButton("Scan LAN", func(args *widget.ButtonClickedEventArgs) {
+			s.Scan()
+		})))
+		s.serversList = widget.NewContainer(
+			widget.ContainerOpts.Layout(widget.NewRowLayout(
+				widget.RowLayoutOpts.Direction(widget.DirectionVertical),
+				widget.RowLayoutOpts.Spacing(5))),
+		)
+		s.serversList.GetWidget().LayoutData = widget.RowLayoutData{Stretch: true}
+		column.AddChild(s.serversList)
+	}
+
+	s.roomsList = widget.NewContainer(
+		widget.ContainerOpts.Layout(widget.NewRowLayout(
+			widget.RowLayoutOpts.Direction(widget.DirectionVertical),
+			widget.RowLayoutOpts.Spacing(5))),
+	)
+	s.roomsList.GetWidget().LayoutData = widget.RowLayoutData{Stretch: true}
+	column.AddChild(s.roomsList)
+
+	var mode *widget.Button
+	mode = stretch(forms.NewButton("Mode: play", func(args *widget.ButtonClickedEventArgs) {
+		s.watch = !s.watch
+		label := "Mode: play"
+		if s.watch {
+			label = "Mode: watch"
+		}
+		mode.Text().Label = label
+	}))
+	column.AddChild(mode)
+
+	s.newRoom = forms.NewTextInput("New room", "")
+	column.AddChild(s.newRoom)
+	column.AddChild(stretch(forms.NewButton("Create room", func(args *widget.ButtonClickedEventArgs) {
+		s.CreateRoom(s.newRoom.GetText())
+	})))
+	column.AddChild(stretch(forms.NewButton("Back", func(args *widget.ButtonClickedEventArgs) {
+		s.back = true
+	})))
+
+	s.ui = &ebitenui.UI{Container: root}
+	return s
+}
+
+func stretch(button *widget.Button) *widget.Button {
+	button.GetWidget().LayoutData = widget.RowLayoutData{
+		Position: widget.RowLayoutPositionCenter,
+		Stretch:  true,
+	}
+	return button
+}
+
+// Connect abre la conexión con la dirección escrita. Se conecta en segundo
+// plano para que la ventana no se congele mientras tanto.
+func (s *LobbyScene) Connect() {
+	if s.dialing != nil {
+		return
+	}
+	s.disconnect()
+	s.url = s.address.GetText()
+	s.status = fmt.Sprintf("Conectando a %s...", s.url)
+	dialing := make(chan dialResult, 1)
+	s.dialing = dialing
+	go func(url string) {
+		client, err := dialServer(url)
+		dialing <- dialResult{client, err}
+	}(s.url)
+}
+
+// Scan busca servidores en la red local en segundo plano.
+func (s *LobbyScene) Scan() {
+	if s.scanning != nil {
+		return
+	}
+	s.status = "Buscando servidores..."
+	scanning := make(chan scanResult, 1)
+	s.scanning = scanning
+	go func() {
+		servers, err := discoverServers()
+		scanning <- scanResult{servers, err}
+	}()
+}
+
+// ShowServers pinta un botón por servidor encontrado. Al pulsarlo se conecta
+// a él.
+func (s *LobbyScene) ShowServers(servers []network.DiscoveredServer) {
+	s.serversList.RemoveChildren()
+	for _, server := range servers {
+		url := server.URL
+		label := fmt.Sprintf("%s   %d players   %dms", server.Name, server.Players, server.Ping.Milliseconds())
+		s.serversList.AddChild(stretch(forms.NewButton(label, func(args *widget.ButtonClickedEventArgs) {
+			s.address.SetText(url)
+			s.Connect()
+		})))
+	}
+}
+
+func (s *LobbyScene) disconnect() {
+	if s.client != nil {
+		s.client.Close()
+		s.client = nil
+	}
+	s.rooms = nil
+	s.listSent = time.Time{}
+	s.ping = 0
+	s.roomsList.RemoveChildren()
+}
+
+// CreateRoom pide una sala nueva y entra en ella en cuanto el servidor la
+// incluya en la lista.
+func (s *LobbyScene) CreateRoom(name string) {
+	if s.client == nil || name == "" {
+		return
+	}
+	s.creating = name
+	s.send(&protocol.CreateRoom{Name: name})
+	s.listSent = time.Now()
+}
+
+// Join deja el vestíbulo y entra en la sala con ClientScene, que se queda
+// con la conexión, para jugar o para mirar según el modo elegido.
+func (s *LobbyScene) Join(room string) {
+	client := s.client
+	s.client = nil
+	if s.watch {
+		s.game.SetScene(game.NewSpectatorScene(s.game, client, room))
+		return
+	}
+	s.game.SetScene(game.NewClientScene(s.game, client, s.name.GetText(), s.team.GetText(), room))
+}
+
+func (s *LobbyScene) send(v interface{}) {
+	message, err := protocol.EncodeAs(s.client.Encoding(), v, 0, 0)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	s.client.Write(message)
+}
+
+func (s *LobbyScene) requestRooms() {
+	s.send(&protocol.ListRooms{})
+	s.listSent = time.Now()
+}
+
+func (s *LobbyScene) Update() error {
+	s.ui.Update()
+	if s.back || inpututil.IsKeyJustPressed(ebiten.KeyEscape) {
+		s.disconnect()
+		s.game.SetScene(NewMenuScene(s.game))
+		return nil
+	}
+
+	if s.scanning != nil {
+		select {
+		case result := <-s.scanning:
+			s.scanning = nil
+			switch {
+			case result.err != nil:
+				log.Println("discovery:", result.err)
+				s.status = "No se pudo buscar servidores"
+			case len(result.servers) == 0:
+				s.status = "No hay servidores en la red local"
+			default:
+				s.status = fmt.Sprintf("%d servidores encontrados", len(result.servers))
+			}
+			s.ShowServers(result.servers)
+		default:
+		}
+	}
+
+	if s.dialing != nil {
+		select {
+		case result := <-s.dialing:
+			s.dialing = nil
+			if result.err != nil {
+				log.Println("dial:", result.err)
+				s.status = fmt.Sprintf("No se pudo conectar a %s", s.url)
+				break
+			}
+			s.client = result.client
+			s.status = ""
+			s.requestRooms()
+		default:
+		}
+	}
+	if s.client == nil {
+		return nil
+	}
+	if s.client.State() == network.StateClosed {
+		s.status = "Desconectado"
+		s.disconnect()
+		return nil
+	}
+
+	s.ReadMessages()
+	if s.client != nil && s.listSent.IsZero() && time.Since(s.listed) > roomsRefresh {
+		s.requestRooms()
+	}
+	return nil
+}
+
+// ReadMessages atiende las respuestas del vestíbulo.
+func (s *LobbyScene) ReadMessages() {
+	for _, m := range s.client.ReadAll() {
+		_, value, err := protocol.Decode(m)
+		if err != nil {
+			log.Printf("cannot decode %s: %v", m, err)
+			continue
+		}
+		switch v := value.(type) {
+		case *protocol.RoomList:
+			if !s.listSent.IsZero() {
+				s.ping = time.Since(s.listSent)
+				s.listSent = time.Time{}
+			}
+			s.listed = time.Now()
+			s.ShowRooms(v.Rooms)
+
+		case *protocol.LobbyError:
+			s.status = v.Reason
+			s.creating = ""
+
+		case *protocol.Reject:
+			s.status = fmt.Sprintf("Rechazado por el servidor: %s", v.Reason)
+			s.disconnect()
+			return
+		}
+	}
+}
+
+// ShowRooms pinta un botón por sala. Si acabamos de crear una, entramos.
+func (s *LobbyScene) ShowRooms(rooms []protocol.RoomInfo) {
+	for _, room := range rooms {
+		if room.Name == s.creating {
+			s.creating = ""
+			s.Join(room.Name)
+			return
+		}
+	}
+	if sameRooms(rooms, s.rooms) {
+		return
+	}
+	s.rooms = rooms
+	s.roomsList.RemoveChildren()
+	for _, room := range rooms {
+		name := room.Name
+		label := fmt.Sprintf("%s   %d/%d", room.Name, room.Players, room.MaxPlayers)
+		if room.Spectators > 0 {
+			label += fmt.Sprintf("   +%d", room.Spectators)
+		}
+		s.roomsList.AddChild(stretch(forms.NewButton(label, func(args *widget.ButtonClickedEventArgs) {
+			s.Join(name)
+		})))
+	}
+}
+
+func sameRooms(a, b []protocol.RoomInfo) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *LobbyScene) Draw(screen *ebiten.Image) {
+	s.ui.Draw(screen)
+
+	text.Draw(screen, "SERVERS", assets.ScoreFont, screenWidth/2-120, 80, color.White)
+	if s.client != nil {
+		ping := s.ping
+		if latency := s.client.Latency(); latency.Samples > 0 {
+			ping = latency.RTT
+		}
+		info := fmt.Sprintf("%s  ping %dms  %d salas", s.url, ping.Milliseconds(), len(s.rooms))
+		text.Draw(screen, info, assets.InfoFont, 10, screenHeight-50, color.White)
+	}
+	if s.status != "" {
+		text.Draw(screen, s.status, assets.InfoFont, 10, screenHeight-20, color.White)
+	}
+}
+
+func (s *LobbyScene) Layout(outsideWidth, outsideHeight int) (int, int) {
+	return screenWidth, screenHeight
+}
+
+func (s *LobbyScene) SpawnElement(name string, element interface{}) {
+
+}
@@ -0,0 +1,190 @@
+package scenes
+
+import (
+	"context"
+	"fmt"
+	"image/color"
+
+	"github.com/ebitenui/ebitenui"
+	e_image "github.com/ebitenui/ebitenui/image"
+	"github.com/ebitenui/ebitenui/widget"
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
+	"github.com/hajimehoshi/ebiten/v2/text"
+
+	"github.com/demonodojo/rabbits/assets"
+	"github.com/demonodojo/rabbits/game"
+	"github.com/demonodojo/rabbits/game/elements/forms"
+	"github.com/demonodojo/rabbits/game/network"
+)
+
+const (
+	serverPort = ":8080"
+)
+
+type menuOption struct {
+	name   string
+	action func()
+}
+
+// MenuScene es el menú principal desde el que se elige el modo de juego.
+type MenuScene struct {
+	game    *game.Game
+	ui      *ebitenui.UI
+	root    *widget.Container
+	options []menuOption
+	status  string
+	exit    bool
+}
+
+func NewMenuScene(g *game.Game) *MenuScene {
+	s := &MenuScene{
+		game: g,
+	}
+
+	s.options = append(s.options,
+		menuOption{name: "Direct", action: s.startDirect},
+		menuOption{name: "Stars", action: s.startStars},
+		menuOption{name: "StarKraft", action: s.startStarKraft},
+	)
+	if canHostServer {
+		s.options = append(s.options,
+			menuOption{name: "Start Server", action: s.startServer},
+			menuOption{name: "Host and Play", action: s.hostAndPlay},
+		)
+	}
+	s.options = append(s.options, menuOption{name: "Connect as Client", action: s.startClient})
+	if canQuit {
+		s.options = append(s.options, menuOption{name: "Exit", action: s.quit})
+	}
+
+	// the root container centers the button column on the screen
+	s.root = widget.NewContainer(
+		widget.ContainerOpts.BackgroundImage(e_image.NewNineSliceColor(color.NRGBA{0x13, 0x1a, 0x22, 0xff})),
+		widget.ContainerOpts.Layout(widget.NewAnchorLayout()),
+	)
+
+	column := widget.NewContainer(
+		widget.ContainerOpts.WidgetOpts(
+			widget.WidgetOpts.LayoutData(widget.AnchorLayoutData{
+				HorizontalPosition: widget.AnchorLayoutPositionCenter,
+				VerticalPosition:   widget.AnchorLayoutPositionCenter,
+			}),
+		),
+		widget.ContainerOpts.Layout(widget.NewRowLayout(
+			widget.RowLayoutOpts.Direction(widget.DirectionVertical),
+			widget.RowLayoutOpts.Spacing(15),
+			widget.RowLayoutOpts.Padding(widget.NewInsetsSimple(20)))),
+	)
+	s.root.AddChild(column)
+
+	for _, option := range s.options {
+		action := option.action
+		button := forms.NewButton(option.name, func(args *widget.ButtonClickedEventArgs) {
+			action()
+		})
+		button.GetWidget().LayoutData = widget.RowLayoutData{
+			Position: widget.RowLayoutPositionCenter,
+			Stretch:  true,
+		}
+		column.AddChild(button)
+	}
+
+	s.ui = &ebitenui.UI{
+		Container: s.root,
+	}
+
+	return s
+}
+
+func (s *MenuScene) Update() error {
+	// Las flechas mueven el foco entre los botones, Enter o Espacio pulsan el
+	// botón enfocado y el ratón funciona directamente sobre los botones.
+	if s.ui.GetFocusedWidget() == nil {
+		s.ui.ChangeFocus(ebitenui.FOCUS_NEXT)
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyDown) {
+		s.ui.ChangeFocus(ebitenui.FOCUS_NEXT)
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyUp) {
+		s.ui.ChangeFocus(ebitenui.FOCUS_PREVIOUS)
+	}
+
+	s.ui.Update()
+	if s.exit {
+		return ebiten.Termination
+	}
+	return nil
+}
+
+func (s *MenuScene) Draw(screen *ebiten.Image) {
+	s.ui.Draw(screen)
+
+	text.Draw(screen, "RABBITS", assets.ScoreFont, screenWidth/2-110, 80, color.White)
+	if s.status != "" {
+		text.Draw(screen, s.status, assets.InfoFont, 10, screenHeight-20, color.White)
+	}
+}
+
+func (s *MenuScene) Layout(outsideWidth, outsideHeight int) (int, int) {
+	return screenWidth, screenHeight
+}
+
+func (s *MenuScene) SpawnElement(name string, element interface{}) {
+
+}
+
+func (s *MenuScene) startDirect() {
+	s.game.SetScene(game.NewRabbitDirectScene(s.game))
+}
+
+func (s *MenuScene) startStars() {
+	s.game.SetScene(NewStarsDirectScene(s.game))
+}
+
+func (s *MenuScene) startStarKraft() {
+	s.game.SetScene(NewStarKraftDirectScene(s.game))
+}
+
+func (s *MenuScene) startServer() {
+	fmt.Println("Iniciando en modo servidor...")
+	if server := s.listen(); server != nil {
+		s.game.SetScene(game.NewServerScene(s.game, server))
+	}
+}
+
+// hostAndPlay abre un servidor como startServer y entra a jugar en él sin
+// pasar por la red. Con Esc se cierra el servidor y se vuelve al menú.
+func (s *MenuScene) hostAndPlay() {
+	server := s.listen()
+	if server == nil {
+		return
+	}
+	scene, stop := game.HostAndPlay(context.Background(), s.game, server, "", "", "")
+	scene.OnLeave = func() {
+		stop()
+		s.game.SetScene(NewMenuScene(s.game))
+	}
+	s.game.SetScene(scene)
+}
+
+// listen abre un servidor en serverPort. Si no puede, por ejemplo porque el
+// puerto está ocupado, lo dice en el menú y devuelve nil.
+func (s *MenuScene) listen() *network.Server {
+	server := network.NewServer(serverPort)
+	server.Discovery = network.DefaultDiscoveryAddr
+	if err := server.Start(); err != nil {
+		server.Shutdown(context.Background())
+		s.status = fmt.Sprintf("No se puede abrir el servidor: %v", err)
+		return nil
+	}
+	return server
+}
+
+func (s *MenuScene) startClient() {
+	s.game.SetScene(NewLobbyScene(s.game))
+}
+
+func (s *MenuScene) quit() {
+	s.exit = true
+}
@@ -1,7 +1,6 @@
 package scenes
 
 import (
-	"encoding/json"
 	"fmt"
 	"image/color"
 	"log"
@@ -19,6 +18,7 @@ import (
 	"github.com/demonodojo/rabbits/game/elements"
 	"github.com/demonodojo/rabbits/game/elements/forms"
 	"github.com/demonodojo/rabbits/game/network"
+	"github.com/demonodojo/rabbits/game/protocol"
 )
 
 const (
@@ -230,22 +230,21 @@ func (s *StarsDirectScene) Spawn() {
 	messages := s.spawns.ReadAll()
 
 	for _, m := range messages {
-		jsonData := []byte(m)
-		var serial game.Serial
-		if err := json.Unmarshal(jsonData, &serial); err != nil {
-			log.Fatal(fmt.Errorf("Cannot unmarshal %s", m))
+		env, value, err := protocol.Decode(m)
+		if err != nil {
+			log.Printf("Cannot decode %s: %v", m, err)
 			continue
 		}
-		switch serial.ClassName {
-		case "Star":
-			if serial.Action == "EDIT" {
-				existing := s.starById(serial.ID)
+		switch v := value.(type) {
+		case *elements.Star:
+			if v.Action == "EDIT" {
+				existing := s.starById(v.ID)
 				newForm := forms.NewStarForm(existing)
 				s.starForm = newForm
 			}
 
 		default:
-			log.Printf("Cannot unmarshal the Elemetnt %s", m)
+			log.Printf("Cannot handle the Element %s", env.Type)
 		}
 	}
 }
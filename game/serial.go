@@ -1,11 +1,7 @@
 package game
 
 import (
-	"github.com/google/uuid"
+	"github.com/demonodojo/rabbits/game/sim"
 )
 
-type Serial struct {
-	ID        uuid.UUID `json:"id"`
-	ClassName string    `json:"class_name"`
-	Action    string    `json:"action"`
-}
+type Serial = sim.Serial
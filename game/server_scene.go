@@ -1,87 +1,77 @@
 package game
 
 import (
-	"encoding/json"
 	"fmt"
 	"image/color"
-	"log"
-	"sync"
 	"time"
 
-	"github.com/google/uuid"
 	"github.com/hajimehoshi/ebiten/v2"
 	"github.com/hajimehoshi/ebiten/v2/text"
 	"golang.org/x/image/math/f64"
 
 	"github.com/demonodojo/rabbits/assets"
+	"github.com/demonodojo/rabbits/game/match"
 	"github.com/demonodojo/rabbits/game/network"
+	"github.com/demonodojo/rabbits/game/replay"
 )
 
+// ServerScene lleva las salas de un match.Lobby y muestra en una ventana la
+// sala por defecto.
 type ServerScene struct {
-	game              *Game
-	camera            *Camera
-	server            *network.Server
-	lettuceSpawnTimer *Timer
-	rabbits           map[uuid.UUID]*Rabbit
-	lettuces          map[uuid.UUID]*Lettuce
-	bullets           map[uuid.UUID]*Bullet
-	lastUpdateTime    time.Time
+	game           *Game
+	camera         *Camera
+	server         *network.Server
+	lobby          *match.Lobby
+	recorder       *replay.Recorder
+	lastUpdateTime time.Time
 
 	score         int
 	scale         float64
 	baseVelocity  float64
 	velocityTimer *Timer
-	mutex         sync.Mutex
 }
 
 func NewServerScene(g *Game, server *network.Server) *ServerScene {
 	s := &ServerScene{
-		game:              g,
-		camera:            &Camera{ViewPort: f64.Vec2{screenWidth, screenHeight}},
-		rabbits:           make(map[uuid.UUID]*Rabbit),
-		lettuces:          make(map[uuid.UUID]*Lettuce),
-		bullets:           make(map[uuid.UUID]*Bullet),
-		lettuceSpawnTimer: NewTimer(lettuceSpawnTime),
-		server:            server,
-		baseVelocity:      baseMeteorVelocity,
-		velocityTimer:     NewTimer(meteorSpeedUpTime),
-		lastUpdateTime:    time.Now(),
+		game:           g,
+		camera:         &Camera{ViewPort: f64.Vec2{screenWidth, screenHeight}},
+		server:         server,
+		lobby:          match.NewLobby(server),
+		baseVelocity:   baseMeteorVelocity,
+		velocityTimer:  NewTimer(meteorSpeedUpTime),
+		lastUpdateTime: time.Now(),
 	}
 
 	return s
 }
 
-func (s *ServerScene) Update() error {
-
-	s.CheckTime()
-	s.UpdateRabbits()
-
-	s.lettuceSpawnTimer.Update()
-	if s.lettuceSpawnTimer.IsReady() {
-		s.lettuceSpawnTimer.Reset()
+// SetSnapshotRate cambia cuántos snapshots por segundo se mandan a los
+// clientes en todas las salas.
+func (s *ServerScene) SetSnapshotRate(rate int) {
+	s.lobby.SnapshotRate = rate
+}
 
-		if len(s.lettuces) < 20 {
-			l := NewLettuce()
-			s.lettuces[l.ID] = l
-			s.server.Broadcast(l.ToJson())
-		}
-	}
+// Lobby devuelve el vestíbulo con las salas del servidor.
+func (s *ServerScene) Lobby() *match.Lobby {
+	return s.lobby
+}
 
-	for _, l := range s.lettuces {
-		l.Update()
-	}
+// Record graba la partida de la sala por defecto con recorder, también
+// después de reiniciarla. Cerrar el Recorder es cosa de quien lo crea.
+func (s *ServerScene) Record(recorder *replay.Recorder) {
+	s.recorder = recorder
+	s.defaultMatch().Recorder = recorder
+}
 
-	for _, rabbit := range s.rabbits {
-		rabbit.Update()
-	}
+// defaultMatch es la partida que se dibuja: la de la sala por defecto.
+func (s *ServerScene) defaultMatch() *match.Match {
+	return s.lobby.Room(match.DefaultRoom).Match
+}
 
-	for _, l := range s.lettuces {
-		l.Update()
-	}
+func (s *ServerScene) Update() error {
 
-	for _, l := range s.lettuces {
-		l.Update()
-	}
+	s.CheckTime()
+	s.lobby.Update()
 
 	if ebiten.IsKeyPressed(ebiten.Key1) {
 		ebiten.SetFullscreen(true)
@@ -91,38 +81,6 @@ func (s *ServerScene) Update() error {
 		ebiten.SetFullscreen(false)
 	}
 
-	for _, b := range s.bullets {
-		b.Update()
-		if b.Action == "DELETE" {
-			delete(s.bullets, b.ID)
-			s.server.Broadcast(b.ToJson())
-		}
-	}
-
-	for _, r := range s.rabbits {
-		for _, b := range s.bullets {
-			if r.Collider().Intersects(b.Collider()) {
-				r.Fired()
-				b.Action = "DELETE"
-				s.server.Broadcast(r.ToJson())
-			}
-		}
-	}
-
-	for _, r := range s.rabbits {
-		for il, l := range s.lettuces {
-			if l.Collider().Intersects(r.Collider()) {
-				delete(s.lettuces, il)
-				l.Action = "Delete"
-				s.server.Broadcast(l.ToJson())
-				r.Score++
-				r.Action = "Score"
-				s.server.Broadcast(r.ToJson())
-				break
-			}
-		}
-	}
-
 	return nil
 }
 
@@ -133,25 +91,29 @@ func (g *ServerScene) Draw(screen *ebiten.Image) {
 	opts.GeoM.Scale(g.scale, g.scale)
 	// Dibuja la imagen en la pantalla con las opciones de escala.
 
-	for _, rabbit := range g.rabbits {
-		rabbit.Draw(screen, g.camera.Matrix)
+	world := g.defaultMatch().World()
+	for _, id := range GetOrderedIds(world.Rabbits) {
+		WrapRabbit(g.game, world.Rabbits[id]).Draw(screen, g.camera.Matrix)
 	}
 
-	for _, m := range g.lettuces {
-		m.Draw(screen, g.camera.Matrix)
+	for _, id := range GetOrderedIds(world.Lettuces) {
+		WrapLettuce(world.Lettuces[id]).Draw(screen, g.camera.Matrix)
 	}
 
-	for _, b := range g.bullets {
-		b.Draw(screen, g.camera.Matrix)
+	for _, id := range GetOrderedIds(world.Bullets) {
+		WrapBullet(world.Bullets[id]).Draw(screen, g.camera.Matrix)
 	}
 
 	text.Draw(screen, fmt.Sprintf("%06d", g.score), assets.ScoreFont, screenWidth/2-100, 50, color.White)
-	text.Draw(screen, fmt.Sprintf("%06d", len(g.bullets)), assets.InfoFont, 10, 50, color.White)
+	text.Draw(screen, fmt.Sprintf("%06d", len(world.Bullets)), assets.InfoFont, 10, 50, color.White)
 }
 
 func (g *ServerScene) Reset() {
-	g.rabbits = make(map[uuid.UUID]*Rabbit)
-	g.lettuces = nil
+	g.lobby.ResetRoom(match.DefaultRoom)
+	if g.recorder != nil {
+		g.recorder.Restart()
+		g.defaultMatch().Recorder = g.recorder
+	}
 	g.score = 0
 	g.baseVelocity = baseMeteorVelocity
 	g.velocityTimer.Reset()
@@ -165,51 +127,6 @@ func (s *ServerScene) SpawnElement(name string, element interface{}) {
 
 }
 
-func (s *ServerScene) UpdateRabbits() {
-
-	messages := s.server.ReadAll()
-
-	for _, m := range messages {
-		jsonData := []byte(m.Message)
-		var serial Serial
-		if err := json.Unmarshal(jsonData, &serial); err != nil {
-			log.Fatal(fmt.Errorf("cannot unmarshal %s", m.Message))
-			continue
-		}
-
-		switch serial.ClassName {
-		case "Rabbit":
-			var rabbit Rabbit
-			if err := json.Unmarshal(jsonData, &rabbit); err != nil {
-				log.Fatal(fmt.Errorf("cannot unmarshal the Rabbit %s", m.Message))
-				continue
-			}
-
-			if serial.Action == "FIRE" {
-				position, rotation := rabbit.advancedPosition()
-				b := NewBullet(position, rotation)
-				s.bullets[b.ID] = b
-				s.server.Broadcast(b.ToJson())
-			} else {
-
-				s.server.Broadcast(m.Message)
-				existing := s.rabbits[rabbit.ID]
-				if existing != nil {
-					existing.CopyFrom(&rabbit)
-				} else {
-					newRabbit := NewRabbit(s.game)
-					newRabbit.CopyFrom(&rabbit)
-					s.rabbits[rabbit.ID] = newRabbit
-				}
-			}
-		case "Lettuce":
-			log.Fatal("lettuce not implemented")
-		default:
-			log.Printf("cannot unmarshal the Rabbit %s", m.Message)
-		}
-	}
-}
-
 func (s *ServerScene) CheckTime() {
 	now := time.Now()
 	delta := now.Sub(s.lastUpdateTime)
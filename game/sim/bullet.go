@@ -0,0 +1,78 @@
+package sim
+
+import (
+	"encoding/json"
+	"math"
+
+	"github.com/google/uuid"
+)
+
+const (
+	BulletWidth  = 13 * 0.2
+	BulletHeight = 37 * 0.2
+
+	bulletSpeedPerTick = 400.0 / TickRate
+	bulletLife         = 60
+)
+
+type Bullet struct {
+	Serial
+	Position Vector
+	Rotation float64
+	Life     int
+
+	// Shooter y Rewind solo los usa el servidor para compensar la latencia:
+	// la bala se comprueba contra los demás conejos donde estaban Rewind
+	// ticks antes, que es donde los veía quien disparó.
+	Shooter uuid.UUID `json:"-"`
+	Rewind  uint64    `json:"-"`
+}
+
+// NewBullet crea una bala centrada en pos.
+func NewBullet(id uuid.UUID, pos Vector, rotation float64) *Bullet {
+	pos.X -= BulletWidth / 2
+	pos.Y -= BulletHeight / 2
+
+	return &Bullet{
+		Serial: Serial{
+			ID:        id,
+			ClassName: "Bullet",
+			Action:    "Spawn",
+		},
+		Position: pos,
+		Rotation: rotation,
+		Life:     bulletLife,
+	}
+}
+
+// Step avanza la bala un tick y la marca para borrar cuando se agota.
+func (b *Bullet) Step() {
+	b.Position.X += math.Sin(b.Rotation) * bulletSpeedPerTick
+	b.Position.Y += math.Cos(b.Rotation) * -bulletSpeedPerTick
+	b.Life--
+	if b.Life <= 0 {
+		b.Action = "DELETE"
+	}
+}
+
+func (b *Bullet) Collider() Rect {
+	return NewRect(
+		b.Position.X,
+		b.Position.Y,
+		BulletWidth,
+		BulletHeight,
+	)
+}
+
+func (b *Bullet) ToJson() string {
+	json, _ := json.Marshal(b)
+	return string(json)
+}
+
+func (b *Bullet) CopyFrom(other *Bullet) {
+	b.ID = other.ID
+	b.Action = other.Action
+	b.Position = other.Position
+	b.Rotation = other.Rotation
+	b.Life = other.Life
+}
@@ -0,0 +1,289 @@
+package sim
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/demonodojo/rabbits/game/protocol"
+)
+
+func init() {
+	protocol.Register[Rabbit]("Rabbit")
+	protocol.Register[Bullet]("Bullet")
+	protocol.Register[Lettuce]("Lettuce")
+	protocol.Register[Input]("Input")
+	protocol.Register[SnapshotDelta]("Snapshot")
+	protocol.Register[SnapshotAck]("SnapshotAck")
+
+	protocol.RegisterBinary("Rabbit", rabbitCodec{})
+	protocol.RegisterBinary("Bullet", bulletCodec{})
+	protocol.RegisterBinary("Lettuce", lettuceCodec{})
+	protocol.RegisterBinary("Input", inputCodec{})
+	protocol.RegisterBinary("Snapshot", snapshotCodec{})
+	protocol.RegisterBinary("SnapshotAck", snapshotAckCodec{})
+}
+
+// El nombre de la clase no viaja en binario: lo da el tipo del sobre.
+func writeSerial(w *protocol.Writer, s Serial) {
+	w.UUID(s.ID)
+	w.String(s.Action)
+}
+
+func readSerial(r *protocol.Reader, className string) Serial {
+	return Serial{ID: r.UUID(), ClassName: className, Action: r.String()}
+}
+
+func writePosition(w *protocol.Writer, v Vector) {
+	w.Position(v.X)
+	w.Position(v.Y)
+}
+
+func readPosition(r *protocol.Reader) Vector {
+	return Vector{X: r.Position(), Y: r.Position()}
+}
+
+func unexpected(v interface{}) error {
+	return fmt.Errorf("sim: unexpected type %T", v)
+}
+
+type rabbitCodec struct{}
+
+func (rabbitCodec) MarshalBinary(v interface{}, w *protocol.Writer) error {
+	r, ok := v.(*Rabbit)
+	if !ok {
+		return unexpected(v)
+	}
+	writeRabbit(w, r)
+	return nil
+}
+
+func (rabbitCodec) UnmarshalBinary(r *protocol.Reader) (interface{}, error) {
+	return readRabbit(r), r.Err()
+}
+
+func writeRabbit(w *protocol.Writer, r *Rabbit) {
+	writeSerial(w, r.Serial)
+	w.String(r.Name)
+	writePosition(w, r.Position)
+	w.Rotation(r.Rotation)
+	w.Speed(r.Speed)
+	w.Varint(int64(r.Score))
+	w.Varint(r.Heat)
+	w.Varint(r.Load)
+	w.Uvarint(uint64(r.LastInput))
+}
+
+func readRabbit(r *protocol.Reader) *Rabbit {
+	return &Rabbit{
+		Serial:    readSerial(r, "Rabbit"),
+		Name:      r.String(),
+		Position:  readPosition(r),
+		Rotation:  r.Rotation(),
+		Speed:     r.Speed(),
+		Score:     int32(r.Varint()),
+		Heat:      r.Varint(),
+		Load:      r.Varint(),
+		LastInput: uint32(r.Uvarint()),
+	}
+}
+
+type bulletCodec struct{}
+
+func (bulletCodec) MarshalBinary(v interface{}, w *protocol.Writer) error {
+	b, ok := v.(*Bullet)
+	if !ok {
+		return unexpected(v)
+	}
+	writeBullet(w, b)
+	return nil
+}
+
+func (bulletCodec) UnmarshalBinary(r *protocol.Reader) (interface{}, error) {
+	return readBullet(r), r.Err()
+}
+
+func writeBullet(w *protocol.Writer, b *Bullet) {
+	writeSerial(w, b.Serial)
+	writePosition(w, b.Position)
+	w.Rotation(b.Rotation)
+	w.Varint(int64(b.Life))
+}
+
+func readBullet(r *protocol.Reader) *Bullet {
+	return &Bullet{
+		Serial:   readSerial(r, "Bullet"),
+		Position: readPosition(r),
+		Rotation: r.Rotation(),
+		Life:     int(r.Varint()),
+	}
+}
+
+type lettuceCodec struct{}
+
+func (lettuceCodec) MarshalBinary(v interface{}, w *protocol.Writer) error {
+	l, ok := v.(*Lettuce)
+	if !ok {
+		return unexpected(v)
+	}
+	writeLettuce(w, l)
+	return nil
+}
+
+func (lettuceCodec) UnmarshalBinary(r *protocol.Reader) (interface{}, error) {
+	return readLettuce(r), r.Err()
+}
+
+func writeLettuce(w *protocol.Writer, l *Lettuce) {
+	writeSerial(w, l.Serial)
+	writePosition(w, l.Position)
+}
+
+func readLettuce(r *protocol.Reader) *Lettuce {
+	return &Lettuce{
+		Serial:   readSerial(r, "Lettuce"),
+		Position: readPosition(r),
+	}
+}
+
+const (
+	inputLeft = 1 << iota
+	inputRight
+	inputThrust
+	inputBrake
+	inputFire
+)
+
+// inputCodec guarda los botones en un solo byte.
+type inputCodec struct{}
+
+func (inputCodec) MarshalBinary(v interface{}, w *protocol.Writer) error {
+	var in Input
+	switch t := v.(type) {
+	case Input:
+		in = t
+	case *Input:
+		in = *t
+	default:
+		return unexpected(v)
+	}
+	writeSerial(w, in.Serial)
+	w.Uvarint(uint64(in.Sequence))
+	var buttons byte
+	if in.Left {
+		buttons |= inputLeft
+	}
+	if in.Right {
+		buttons |= inputRight
+	}
+	if in.Thrust {
+		buttons |= inputThrust
+	}
+	if in.Brake {
+		buttons |= inputBrake
+	}
+	if in.Fire {
+		buttons |= inputFire
+	}
+	w.Byte(buttons)
+	if in.ViewTick != 0 {
+		w.Uvarint(in.ViewTick)
+	}
+	return nil
+}
+
+func (inputCodec) UnmarshalBinary(r *protocol.Reader) (interface{}, error) {
+	in := &Input{
+		Serial:   readSerial(r, "Input"),
+		Sequence: uint32(r.Uvarint()),
+	}
+	buttons := r.Byte()
+	in.Left = buttons&inputLeft != 0
+	in.Right = buttons&inputRight != 0
+	in.Thrust = buttons&inputThrust != 0
+	in.Brake = buttons&inputBrake != 0
+	if r.More() {
+		in.ViewTick = r.Uvarint()
+	}
+	in.Fire = buttons&inputFire != 0
+	return in, r.Err()
+}
+
+// maxSnapshotEntities acota las listas de un snapshot recibido para que un
+// mensaje corrupto no reserve memoria sin límite.
+const maxSnapshotEntities = 4096
+
+var errTooManyEntities = errors.New("sim: too many entities in snapshot")
+
+func readCount(r *protocol.Reader) int {
+	n := r.Uvarint()
+	if n > maxSnapshotEntities {
+		r.Fail(errTooManyEntities)
+		return 0
+	}
+	return int(n)
+}
+
+type snapshotCodec struct{}
+
+func (snapshotCodec) MarshalBinary(v interface{}, w *protocol.Writer) error {
+	d, ok := v.(*SnapshotDelta)
+	if !ok {
+		return unexpected(v)
+	}
+	w.Uvarint(d.Tick)
+	w.Uvarint(d.Base)
+	w.Bool(d.Full)
+	w.Uvarint(uint64(len(d.Rabbits)))
+	for _, r := range d.Rabbits {
+		writeRabbit(w, r)
+	}
+	w.Uvarint(uint64(len(d.Bullets)))
+	for _, b := range d.Bullets {
+		writeBullet(w, b)
+	}
+	w.Uvarint(uint64(len(d.Lettuces)))
+	for _, l := range d.Lettuces {
+		writeLettuce(w, l)
+	}
+	w.Uvarint(uint64(len(d.Removed)))
+	for _, id := range d.Removed {
+		w.UUID(id)
+	}
+	return nil
+}
+
+func (snapshotCodec) UnmarshalBinary(r *protocol.Reader) (interface{}, error) {
+	d := &SnapshotDelta{
+		Tick: r.Uvarint(),
+		Base: r.Uvarint(),
+		Full: r.Bool(),
+	}
+	for i, n := 0, readCount(r); i < n; i++ {
+		d.Rabbits = append(d.Rabbits, readRabbit(r))
+	}
+	for i, n := 0, readCount(r); i < n; i++ {
+		d.Bullets = append(d.Bullets, readBullet(r))
+	}
+	for i, n := 0, readCount(r); i < n; i++ {
+		d.Lettuces = append(d.Lettuces, readLettuce(r))
+	}
+	for i, n := 0, readCount(r); i < n; i++ {
+		d.Removed = append(d.Removed, r.UUID())
+	}
+	return d, r.Err()
+}
+
+type snapshotAckCodec struct{}
+
+func (snapshotAckCodec) MarshalBinary(v interface{}, w *protocol.Writer) error {
+	ack, ok := v.(*SnapshotAck)
+	if !ok {
+		return unexpected(v)
+	}
+	w.Uvarint(ack.Tick)
+	return nil
+}
+
+func (snapshotAckCodec) UnmarshalBinary(r *protocol.Reader) (interface{}, error) {
+	return &SnapshotAck{Tick: r.Uvarint()}, r.Err()
+}
@@ -0,0 +1,42 @@
+package sim
+
+import (
+	"encoding/json"
+
+	"github.com/google/uuid"
+)
+
+// Input es el comando que un cliente envía al servidor en lugar de su estado.
+// El servidor es quien aplica el movimiento, el calor y la puntuación.
+type Input struct {
+	Serial
+	Sequence uint32 `json:"sequence"`
+	Left     bool   `json:"left,omitempty"`
+	Right    bool   `json:"right,omitempty"`
+	Thrust   bool   `json:"thrust,omitempty"`
+	Brake    bool   `json:"brake,omitempty"`
+	Fire     bool   `json:"fire,omitempty"`
+	// ViewTick es el tick del servidor que el cliente estaba dibujando al
+	// generar el comando. Con él se comprueban sus disparos contra lo que vio.
+	ViewTick uint64 `json:"view_tick,omitempty"`
+}
+
+func NewInput(id uuid.UUID, sequence uint32) Input {
+	return Input{
+		Serial: Serial{
+			ID:        id,
+			ClassName: "Input",
+			Action:    "INPUT",
+		},
+		Sequence: sequence,
+	}
+}
+
+func (in Input) Active() bool {
+	return in.Left || in.Right || in.Thrust || in.Brake || in.Fire
+}
+
+func (in Input) ToJson() string {
+	json, _ := json.Marshal(in)
+	return string(json)
+}
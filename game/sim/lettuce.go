@@ -0,0 +1,57 @@
+package sim
+
+import (
+	"encoding/json"
+	"math/rand"
+
+	"github.com/google/uuid"
+)
+
+const (
+	LettuceWidth  = 16 * 4
+	LettuceHeight = 16 * 4
+)
+
+type Lettuce struct {
+	Serial
+	Position Vector
+}
+
+func NewLettuce(id uuid.UUID, pos Vector) *Lettuce {
+	return &Lettuce{
+		Serial: Serial{
+			ID:        id,
+			ClassName: "Lettuce",
+			Action:    "Spawn",
+		},
+		Position: pos,
+	}
+}
+
+// RandomLettucePosition elige un sitio para una lechuga dentro del mundo.
+func RandomLettucePosition(rng *rand.Rand) Vector {
+	return Vector{
+		X: (WorldWidth - LettuceWidth) * rng.Float64(),
+		Y: (WorldHeight - LettuceHeight) * rng.Float64(),
+	}
+}
+
+func (l *Lettuce) Collider() Rect {
+	return NewRect(
+		l.Position.X,
+		l.Position.Y,
+		LettuceWidth,
+		LettuceHeight,
+	)
+}
+
+func (l *Lettuce) ToJson() string {
+	json, _ := json.Marshal(l)
+	return string(json)
+}
+
+func (l *Lettuce) CopyFrom(other *Lettuce) {
+	l.ID = other.ID
+	l.Action = other.Action
+	l.Position = other.Position
+}
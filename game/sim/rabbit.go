@@ -0,0 +1,145 @@
+package sim
+
+import (
+	"encoding/json"
+	"math"
+
+	"github.com/google/uuid"
+)
+
+const (
+	RabbitWidth  = 99 * 0.4
+	RabbitHeight = 75 * 0.4
+
+	rabbitRotationPerTick = math.Pi / TickRate
+	rabbitSpeedPerTick    = 0.1
+	rabbitMaxSpeed        = 5
+	rabbitMaxHeat         = 100
+	rabbitFireHeat        = 30
+	rabbitFireLoad        = 30
+)
+
+// Rabbit es el estado simulado de un conejo, sin nada de dibujo.
+type Rabbit struct {
+	Serial
+	Name      string  `json:"name,omitempty"`
+	Position  Vector  `json:"position"`
+	Rotation  float64 `json:"rotation"`
+	Speed     float64 `json:"speed"`
+	Score     int32   `json:"score"`
+	Heat      int64   `json:"heat"`
+	Load      int64   `json:"load"`
+	LastInput uint32  `json:"last_input"`
+}
+
+func NewRabbit(id uuid.UUID) *Rabbit {
+	return &Rabbit{
+		Serial: Serial{
+			ID:        id,
+			ClassName: "Rabbit",
+			Action:    "Spawn",
+		},
+		Position: Vector{
+			X: WorldWidth/2 - RabbitWidth/2,
+			Y: WorldHeight/2 - RabbitHeight/2,
+		},
+	}
+}
+
+// ApplyInput aplica un comando sobre el conejo. Devuelve true si el conejo ha
+// disparado, es decir, si el comando pedía disparar y el calor y la carga lo
+// permitían.
+func (r *Rabbit) ApplyInput(in Input) bool {
+	fired := false
+	if in.Left {
+		r.Rotation -= rabbitRotationPerTick
+	}
+	if in.Right {
+		r.Rotation += rabbitRotationPerTick
+	}
+
+	if in.Thrust {
+		if r.Speed < rabbitMaxSpeed {
+			r.Speed += rabbitSpeedPerTick
+		}
+	}
+
+	if in.Brake {
+		if r.Speed > -rabbitMaxSpeed {
+			r.Speed -= rabbitSpeedPerTick
+		}
+	}
+
+	if in.Fire {
+		if r.Heat < rabbitMaxHeat && r.Load == 0 {
+			r.Action = "FIRE"
+			r.Load = rabbitFireLoad
+			r.Heat += rabbitFireHeat
+			fired = true
+		}
+	}
+
+	r.LastInput = in.Sequence
+	return fired
+}
+
+// Step avanza el conejo exactamente un tick.
+func (r *Rabbit) Step() {
+	r.Position.X += math.Sin(r.Rotation) * r.Speed
+	r.Position.Y += math.Cos(r.Rotation) * (-r.Speed)
+	if r.Heat > 0 {
+		r.Heat--
+	}
+	if r.Load > 0 {
+		r.Load--
+	}
+}
+
+// Simulate aplica un comando y avanza un tick. Es la misma operación en el
+// servidor y en la predicción del cliente, por eso ambos coinciden.
+func (r *Rabbit) Simulate(in Input) bool {
+	fired := r.ApplyInput(in)
+	r.Step()
+	return fired
+}
+
+// AdvancedPosition es el punto delante del conejo donde aparecen sus balas.
+func (r *Rabbit) AdvancedPosition() (Vector, float64) {
+	position := Vector{
+		X: r.Position.X + RabbitWidth/2 + math.Sin(r.Rotation)*40,
+		Y: r.Position.Y + RabbitHeight/2 - math.Cos(r.Rotation)*40,
+	}
+	return position, r.Rotation
+}
+
+func (r *Rabbit) Fired() {
+	r.Speed = 0
+	r.Score -= 1
+}
+
+func (r *Rabbit) Collider() Rect {
+	return NewRect(
+		r.Position.X,
+		r.Position.Y,
+		RabbitWidth,
+		RabbitHeight,
+	)
+}
+
+func (r *Rabbit) ToJson() string {
+	json, _ := json.Marshal(r)
+	return string(json)
+}
+
+func (r *Rabbit) CopyFrom(other *Rabbit) {
+	r.ID = other.ID
+	r.Action = other.Action
+	r.Name = other.Name
+	r.Position = other.Position
+	r.Rotation = other.Rotation
+	r.Speed = other.Speed
+	r.Score = other.Score
+	r.Heat = other.Heat
+	r.Load = other.Load
+	r.LastInput = other.LastInput
+}
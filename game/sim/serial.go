@@ -0,0 +1,17 @@
+package sim
+
+import (
+	"github.com/google/uuid"
+)
+
+type Serial struct {
+	ID        uuid.UUID `json:"id"`
+	ClassName string    `json:"class_name"`
+	Action    string    `json:"action"`
+}
+
+// EntityID identifica la entidad de un mensaje, para que las colas puedan
+// quedarse solo con su último estado.
+func (s Serial) EntityID() uuid.UUID {
+	return s.ID
+}
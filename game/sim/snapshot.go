@@ -0,0 +1,141 @@
+package sim
+
+import (
+	"github.com/google/uuid"
+)
+
+// SnapshotHistory es cuántos ticks de snapshots guardan servidor y cliente
+// para poder calcular y aplicar deltas.
+const SnapshotHistory = 2 * TickRate
+
+// Snapshot es una copia del estado del mundo en un tick. Las entidades se
+// guardan por valor para que no cambien al seguir simulando.
+type Snapshot struct {
+	Tick     uint64
+	Rabbits  map[uuid.UUID]Rabbit
+	Bullets  map[uuid.UUID]Bullet
+	Lettuces map[uuid.UUID]Lettuce
+}
+
+func NewSnapshot(tick uint64) *Snapshot {
+	return &Snapshot{
+		Tick:     tick,
+		Rabbits:  make(map[uuid.UUID]Rabbit),
+		Bullets:  make(map[uuid.UUID]Bullet),
+		Lettuces: make(map[uuid.UUID]Lettuce),
+	}
+}
+
+// Snapshot copia el estado actual del mundo.
+func (w *World) Snapshot() *Snapshot {
+	s := NewSnapshot(w.Tick)
+	for id, r := range w.Rabbits {
+		s.Rabbits[id] = *r
+	}
+	for id, b := range w.Bullets {
+		s.Bullets[id] = *b
+	}
+	for id, l := range w.Lettuces {
+		s.Lettuces[id] = *l
+	}
+	return s
+}
+
+// SnapshotDelta es lo que viaja por la red: las entidades nuevas o cambiadas
+// desde el snapshot Base y las que han desaparecido. Si Full es true no hay
+// base y el delta contiene el mundo entero.
+type SnapshotDelta struct {
+	Tick     uint64      `json:"tick"`
+	Base     uint64      `json:"base"`
+	Full     bool        `json:"full"`
+	Rabbits  []*Rabbit   `json:"rabbits,omitempty"`
+	Bullets  []*Bullet   `json:"bullets,omitempty"`
+	Lettuces []*Lettuce  `json:"lettuces,omitempty"`
+	Removed  []uuid.UUID `json:"removed,omitempty"`
+}
+
+// SnapshotAck confirma al servidor que el cliente tiene el snapshot de Tick,
+// para que los siguientes deltas se calculen contra él.
+type SnapshotAck struct {
+	Tick uint64 `json:"tick"`
+}
+
+// Diff calcula el delta que lleva de base a s. Con base nil el delta es
+// completo.
+func (s *Snapshot) Diff(base *Snapshot) *SnapshotDelta {
+	d := &SnapshotDelta{Tick: s.Tick}
+	if base == nil {
+		d.Full = true
+		base = NewSnapshot(0)
+	} else {
+		d.Base = base.Tick
+	}
+
+	for _, id := range OrderedIds(s.Rabbits) {
+		r := s.Rabbits[id]
+		if old, ok := base.Rabbits[id]; !ok || old != r {
+			d.Rabbits = append(d.Rabbits, &r)
+		}
+	}
+	for _, id := range OrderedIds(s.Bullets) {
+		b := s.Bullets[id]
+		if old, ok := base.Bullets[id]; !ok || old != b {
+			d.Bullets = append(d.Bullets, &b)
+		}
+	}
+	for _, id := range OrderedIds(s.Lettuces) {
+		l := s.Lettuces[id]
+		if old, ok := base.Lettuces[id]; !ok || old != l {
+			d.Lettuces = append(d.Lettuces, &l)
+		}
+	}
+
+	for _, id := range OrderedIds(base.Rabbits) {
+		if _, ok := s.Rabbits[id]; !ok {
+			d.Removed = append(d.Removed, id)
+		}
+	}
+	for _, id := range OrderedIds(base.Bullets) {
+		if _, ok := s.Bullets[id]; !ok {
+			d.Removed = append(d.Removed, id)
+		}
+	}
+	for _, id := range OrderedIds(base.Lettuces) {
+		if _, ok := s.Lettuces[id]; !ok {
+			d.Removed = append(d.Removed, id)
+		}
+	}
+	return d
+}
+
+// Apply reconstruye el snapshot completo aplicando d sobre base. Con un delta
+// completo base se ignora.
+func (d *SnapshotDelta) Apply(base *Snapshot) *Snapshot {
+	s := NewSnapshot(d.Tick)
+	if !d.Full && base != nil {
+		for id, r := range base.Rabbits {
+			s.Rabbits[id] = r
+		}
+		for id, b := range base.Bullets {
+			s.Bullets[id] = b
+		}
+		for id, l := range base.Lettuces {
+			s.Lettuces[id] = l
+		}
+	}
+	for _, id := range d.Removed {
+		delete(s.Rabbits, id)
+		delete(s.Bullets, id)
+		delete(s.Lettuces, id)
+	}
+	for _, r := range d.Rabbits {
+		s.Rabbits[r.ID] = *r
+	}
+	for _, b := range d.Bullets {
+		s.Bullets[b.ID] = *b
+	}
+	for _, l := range d.Lettuces {
+		s.Lettuces[l.ID] = *l
+	}
+	return s
+}
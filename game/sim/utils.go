@@ -0,0 +1,22 @@
+package sim
+
+import (
+	"sort"
+
+	"github.com/google/uuid"
+)
+
+// OrderedIds devuelve las claves del mapa ordenadas, para recorrer las
+// entidades siempre en el mismo orden.
+func OrderedIds[T any](m map[uuid.UUID]T) []uuid.UUID {
+
+	keys := make([]uuid.UUID, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].String() < keys[j].String()
+	})
+	return keys
+}
@@ -0,0 +1,262 @@
+package sim
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// TickRate es el número de ticks de simulación por segundo.
+	TickRate     = 60
+	TickDuration = time.Second / TickRate
+
+	WorldWidth  = 800
+	WorldHeight = 600
+
+	lettuceSpawnTime = 1 * time.Second
+)
+
+type EventKind int
+
+const (
+	EventBulletSpawned EventKind = iota
+	EventBulletRemoved
+	EventRabbitHit
+	EventLettuceSpawned
+	EventLettuceEaten
+)
+
+// Event cuenta algo que ha pasado durante un tick, para que la escena lo
+// dibuje o lo envíe a los clientes.
+type Event struct {
+	Kind    EventKind
+	Tick    uint64
+	Rabbit  *Rabbit
+	Bullet  *Bullet
+	Lettuce *Lettuce
+}
+
+// World es la simulación completa de una partida. Avanza a ticks fijos, solo
+// cambia a través de comandos y no lee el reloj, así que con la misma semilla
+// y los mismos comandos siempre da el mismo resultado.
+type World struct {
+	Tick     uint64
+	Rabbits  map[uuid.UUID]*Rabbit
+	Bullets  map[uuid.UUID]*Bullet
+	Lettuces map[uuid.UUID]*Lettuce
+
+	// MaxLettuces limita las lechugas a la vez; 0 es sin límite.
+	MaxLettuces int
+
+	// MaxRewind es cuántos ticks hacia atrás se puede comprobar una bala
+	// para compensar la latencia de quien dispara; 0 no compensa.
+	MaxRewind uint64
+
+	lettuceSpawnTimer *Timer
+	rng               *rand.Rand
+	history           []colliders // los últimos MaxRewind ticks, del más antiguo al más reciente
+}
+
+// colliders son las cajas de los conejos al final de un tick.
+type colliders struct {
+	tick  uint64
+	rects map[uuid.UUID]Rect
+}
+
+func NewWorld(seed int64) *World {
+	return &World{
+		Rabbits:           make(map[uuid.UUID]*Rabbit),
+		Bullets:           make(map[uuid.UUID]*Bullet),
+		Lettuces:          make(map[uuid.UUID]*Lettuce),
+		lettuceSpawnTimer: NewTimer(lettuceSpawnTime),
+		rng:               rand.New(rand.NewSource(seed)),
+	}
+}
+
+// NewID genera un identificador a partir de la semilla del mundo.
+func (w *World) NewID() uuid.UUID {
+	id, err := uuid.NewRandomFromReader(w.rng)
+	if err != nil {
+		panic(err)
+	}
+	return id
+}
+
+func (w *World) AddRabbit(r *Rabbit) {
+	w.Rabbits[r.ID] = r
+}
+
+// SpawnRabbit crea un conejo nuevo en un sitio al azar del mundo.
+func (w *World) SpawnRabbit(name string) *Rabbit {
+	r := NewRabbit(w.NewID())
+	r.Name = name
+	r.Position = Vector{
+		X: (WorldWidth - RabbitWidth) * w.rng.Float64(),
+		Y: (WorldHeight - RabbitHeight) * w.rng.Float64(),
+	}
+	w.AddRabbit(r)
+	return r
+}
+
+func (w *World) RemoveRabbit(id uuid.UUID) {
+	delete(w.Rabbits, id)
+}
+
+func (w *World) SpawnLettuce() *Lettuce {
+	l := NewLettuce(w.NewID(), RandomLettucePosition(w.rng))
+	w.Lettuces[l.ID] = l
+	return l
+}
+
+// Step avanza el mundo un tick aplicando como mucho un comando por conejo.
+func (w *World) Step(inputs map[uuid.UUID]Input) []Event {
+	events := w.ApplyInputs(inputs)
+	return append(events, w.Advance()...)
+}
+
+// ApplyInputs simula un tick de todos los conejos. Los que no tienen comando
+// avanzan con uno vacío: siguen moviéndose y enfriándose como siempre.
+func (w *World) ApplyInputs(inputs map[uuid.UUID]Input) []Event {
+	var events []Event
+	for _, id := range OrderedIds(w.Rabbits) {
+		r := w.Rabbits[id]
+		in, ok := inputs[id]
+		if !ok {
+			in = NewInput(id, r.LastInput)
+		}
+		events = append(events, w.simulate(r, in)...)
+	}
+	return events
+}
+
+// CatchUp simula un tick de más solo de los conejos que tienen comando, para
+// que se pongan al día los que van atrasados.
+func (w *World) CatchUp(inputs map[uuid.UUID]Input) []Event {
+	var events []Event
+	for _, id := range OrderedIds(inputs) {
+		if r := w.Rabbits[id]; r != nil {
+			events = append(events, w.simulate(r, inputs[id])...)
+		}
+	}
+	return events
+}
+
+func (w *World) simulate(r *Rabbit, in Input) []Event {
+	var events []Event
+	if r.Simulate(in) {
+		position, rotation := r.AdvancedPosition()
+		b := NewBullet(w.NewID(), position, rotation)
+		b.Shooter = r.ID
+		b.Rewind = w.rewind(in.ViewTick)
+		w.Bullets[b.ID] = b
+		events = append(events, Event{Kind: EventBulletSpawned, Tick: w.Tick, Rabbit: r, Bullet: b})
+	}
+	r.Action = "NONE"
+	return events
+}
+
+// Advance mueve todo lo que no depende de los comandos: balas, lechugas y
+// colisiones.
+func (w *World) Advance() []Event {
+	var events []Event
+
+	w.lettuceSpawnTimer.Update()
+	if w.lettuceSpawnTimer.IsReady() {
+		w.lettuceSpawnTimer.Reset()
+		if w.MaxLettuces == 0 || len(w.Lettuces) < w.MaxLettuces {
+			l := w.SpawnLettuce()
+			events = append(events, Event{Kind: EventLettuceSpawned, Tick: w.Tick, Lettuce: l})
+		}
+	}
+
+	for _, id := range OrderedIds(w.Bullets) {
+		b := w.Bullets[id]
+		b.Step()
+		if b.Action == "DELETE" {
+			delete(w.Bullets, id)
+			events = append(events, Event{Kind: EventBulletRemoved, Tick: w.Tick, Bullet: b})
+		}
+	}
+
+	rabbitIds := OrderedIds(w.Rabbits)
+	for _, rid := range rabbitIds {
+		r := w.Rabbits[rid]
+		for _, bid := range OrderedIds(w.Bullets) {
+			b := w.Bullets[bid]
+			collider, ok := w.colliderFor(r, b)
+			if ok && collider.Intersects(b.Collider()) {
+				r.Fired()
+				b.Action = "DELETE"
+				delete(w.Bullets, bid)
+				events = append(events,
+					Event{Kind: EventRabbitHit, Tick: w.Tick, Rabbit: r, Bullet: b},
+					Event{Kind: EventBulletRemoved, Tick: w.Tick, Bullet: b},
+				)
+			}
+		}
+	}
+
+	for _, rid := range rabbitIds {
+		r := w.Rabbits[rid]
+		for _, lid := range OrderedIds(w.Lettuces) {
+			l := w.Lettuces[lid]
+			if l.Collider().Intersects(r.Collider()) {
+				delete(w.Lettuces, lid)
+				l.Action = "Delete"
+				r.Score++
+				events = append(events, Event{Kind: EventLettuceEaten, Tick: w.Tick, Rabbit: r, Lettuce: l})
+				break
+			}
+		}
+	}
+
+	w.Tick++
+	w.recordColliders()
+	return events
+}
+
+// rewind es cuántos ticks hay que retroceder para ver el mundo como en
+// viewTick, acotado a MaxRewind. Un viewTick desconocido o futuro no
+// retrocede.
+func (w *World) rewind(viewTick uint64) uint64 {
+	if viewTick == 0 || viewTick > w.Tick {
+		return 0
+	}
+	return min(w.Tick-viewTick, w.MaxRewind)
+}
+
+// colliderFor es la caja de r contra la que se comprueba b. Quien dispara se
+// ve a sí mismo en el presente, porque su conejo va predicho, pero a los
+// demás los ve en el pasado. Si r aún no existía entonces no le da.
+func (w *World) colliderFor(r *Rabbit, b *Bullet) (Rect, bool) {
+	if b.Rewind == 0 || r.ID == b.Shooter {
+		return r.Collider(), true
+	}
+	// Los conejos se acaban de mover pero aún no se ha apuntado el tick
+	tick := w.Tick + 1 - b.Rewind
+	for i := len(w.history) - 1; i >= 0; i-- {
+		if w.history[i].tick == tick {
+			rect, ok := w.history[i].rects[r.ID]
+			return rect, ok
+		}
+	}
+	return r.Collider(), true
+}
+
+// recordColliders apunta dónde está cada conejo para poder rebobinar.
+func (w *World) recordColliders() {
+	if w.MaxRewind == 0 {
+		w.history = nil
+		return
+	}
+	frame := colliders{tick: w.Tick, rects: make(map[uuid.UUID]Rect, len(w.Rabbits))}
+	for id, r := range w.Rabbits {
+		frame.rects[id] = r.Collider()
+	}
+	w.history = append(w.history, frame)
+	if excess := len(w.history) - int(w.MaxRewind); excess > 0 {
+		w.history = w.history[excess:]
+	}
+}
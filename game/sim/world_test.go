@@ -0,0 +1,90 @@
+package sim
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+// script es el comando de cada conejo en cada tick: el primero gira, acelera
+// y dispara de vez en cuando; el segundo no manda nada la mitad del tiempo.
+func script(tick uint64, ids []uuid.UUID) map[uuid.UUID]Input {
+	inputs := make(map[uuid.UUID]Input)
+	first := NewInput(ids[0], uint32(tick+1))
+	first.Thrust = tick < 120
+	first.Left = tick%40 < 10
+	first.Fire = tick%25 == 0
+	inputs[ids[0]] = first
+	if tick%2 == 0 {
+		second := NewInput(ids[1], uint32(tick/2+1))
+		second.Thrust = true
+		second.Fire = tick%30 == 0
+		inputs[ids[1]] = second
+	}
+	return inputs
+}
+
+func newTestWorld(seed int64) (*World, []uuid.UUID) {
+	w := NewWorld(seed)
+	w.MaxLettuces = 5
+	a := w.SpawnRabbit("ana")
+	b := w.SpawnRabbit("bob")
+	return w, []uuid.UUID{a.ID, b.ID}
+}
+
+// Dos mundos con la misma semilla y los mismos comandos pasan por los mismos
+// snapshots, tick a tick.
+func TestWorldIsDeterministic(t *testing.T) {
+	w1, ids1 := newTestWorld(42)
+	w2, ids2 := newTestWorld(42)
+	if !reflect.DeepEqual(ids1, ids2) {
+		t.Fatalf("same seed spawned %v and %v", ids1, ids2)
+	}
+
+	for tick := uint64(0); tick < 600; tick++ {
+		w1.Step(script(tick, ids1))
+		w2.Step(script(tick, ids2))
+		if s1, s2 := w1.Snapshot(), w2.Snapshot(); !reflect.DeepEqual(s1, s2) {
+			t.Fatalf("snapshots differ at tick %d:\n%+v\n%+v", tick, s1, s2)
+		}
+	}
+	if len(w1.Lettuces) == 0 {
+		t.Error("no lettuce spawned in 10 s")
+	}
+}
+
+// Otra semilla da otro mundo.
+func TestWorldDependsOnSeed(t *testing.T) {
+	w1, _ := newTestWorld(1)
+	w2, _ := newTestWorld(2)
+	if reflect.DeepEqual(w1.Snapshot(), w2.Snapshot()) {
+		t.Error("different seeds gave the same world")
+	}
+}
+
+// Un conejo sin comando en un tick sigue moviéndose y enfriándose, y no
+// pierde la cuenta de sus comandos.
+func TestRabbitWithoutInputKeepsMoving(t *testing.T) {
+	w, ids := newTestWorld(7)
+	r := w.Rabbits[ids[0]]
+	in := NewInput(r.ID, 1)
+	in.Thrust = true
+	in.Fire = true
+	w.Step(map[uuid.UUID]Input{r.ID: in})
+
+	position, heat, load := r.Position, r.Heat, r.Load
+	if r.Speed == 0 || heat == 0 || load == 0 {
+		t.Fatalf("rabbit did not thrust and fire: %+v", r)
+	}
+	w.Step(nil)
+	if r.Position == position {
+		t.Error("rabbit without input stopped moving")
+	}
+	if r.Heat >= heat || r.Load >= load {
+		t.Errorf("rabbit without input did not cool down: heat %d -> %d, load %d -> %d", heat, r.Heat, load, r.Load)
+	}
+	if r.LastInput != 1 {
+		t.Errorf("LastInput = %d after an idle tick, want 1", r.LastInput)
+	}
+}
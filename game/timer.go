@@ -3,31 +3,11 @@ package game
 import (
 	"time"
 
-	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/demonodojo/rabbits/game/sim"
 )
 
-type Timer struct {
-	currentTicks int
-	targetTicks  int
-}
+type Timer = sim.Timer
 
 func NewTimer(d time.Duration) *Timer {
-	return &Timer{
-		currentTicks: 0,
-		targetTicks:  int(d.Milliseconds()) * ebiten.TPS() / 1000,
-	}
-}
-
-func (t *Timer) Update() {
-	if t.currentTicks < t.targetTicks {
-		t.currentTicks++
-	}
-}
-
-func (t *Timer) IsReady() bool {
-	return t.currentTicks >= t.targetTicks
-}
-
-func (t *Timer) Reset() {
-	t.currentTicks = 0
+	return sim.NewTimer(d)
 }
@@ -2,22 +2,14 @@ package game
 
 import (
 	"math"
-	"sort"
 
 	"github.com/google/uuid"
+
+	"github.com/demonodojo/rabbits/game/sim"
 )
 
 func GetOrderedIds[T any](m map[uuid.UUID]T) []uuid.UUID {
-
-	keys := make([]uuid.UUID, 0, len(m))
-	for k := range m {
-		keys = append(keys, k)
-	}
-
-	sort.Slice(keys, func(i, j int) bool {
-		return keys[i].String() < keys[j].String()
-	})
-	return keys
+	return sim.OrderedIds(m)
 }
 
 func EuclidianDistance(a, b Vector) float64 {
@@ -1,13 +1,7 @@
 package game
 
-import "math"
+import (
+	"github.com/demonodojo/rabbits/game/sim"
+)
 
-type Vector struct {
-	X float64
-	Y float64
-}
-
-func (v Vector) Normalize() Vector {
-	magnitude := math.Sqrt(v.X*v.X + v.Y*v.Y)
-	return Vector{v.X / magnitude, v.Y / magnitude}
-}
+type Vector = sim.Vector
@@ -3,12 +3,16 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
 
 	"github.com/demonodojo/rabbits/game"
+	"github.com/demonodojo/rabbits/game/match"
 	"github.com/demonodojo/rabbits/game/network"
+	"github.com/demonodojo/rabbits/game/protocol"
+	"github.com/demonodojo/rabbits/game/replay"
 	"github.com/demonodojo/rabbits/game/scenes"
 
 	"github.com/hajimehoshi/ebiten/v2"
@@ -18,29 +22,87 @@ func main() {
 	g := &game.Game{}
 	var scene game.Scene
 	serverMode := flag.Bool("server", false, "Inits the application in server mode")
+	hostMode := flag.Bool("host", false, "Hosts a server and plays in it from the same process")
 	clientMode := flag.Bool("client", false, "Inits the application in client mode")
 	directMode := flag.Bool("direct", false, "Inits the application in direct mode")
 	starsMode := flag.Bool("stars", false, "Inits the application in stars mode")
-	url := "ws://localhost:8080/ws"
+	snapshotRate := flag.Int("snapshot-rate", match.DefaultSnapshotRate, "World snapshots per second in server mode")
+	name := flag.String("name", "", "Player name in client mode, server name in server mode")
+	room := flag.String("room", "", "Room to join in client mode, the default room if empty")
+	team := flag.String("team", "", "Team to share the team chat with in client mode")
+	spectate := flag.Bool("spectate", false, "Watch the room in client mode instead of playing")
+	encodingName := flag.String("encoding", "json", "Wire encoding in client mode: json or binary")
+	url := flag.String("url", "ws://localhost:8080/ws", "Server address in client mode")
+	record := flag.String("record", "", "Record the match to this replay file in server or direct mode")
+	replayPath := flag.String("replay", "", "Play back a replay file")
 	// Parsea los flags desde los argumentos de línea de comandos
 	flag.Parse()
 
-	if *serverMode {
+	// Solo graban los modos servidor y directo: en los demás no se crea el
+	// fichero
+	recording := *record != "" && *replayPath == "" && (*serverMode || (*directMode && !*hostMode))
+	if *record != "" && !recording {
+		log.Println("-record only works in server or direct mode, not recording")
+	}
+
+	if *replayPath != "" {
+		rep, err := replay.Open(*replayPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		scene = game.NewReplayScene(g, rep)
+	} else if *serverMode {
 		fmt.Println("Iniciando en modo servidor...")
-		server := network.Server{Port: ":8080"}
-		server.Start()
-		scene = game.NewServerScene(g, &server)
+		server := network.NewServer(":8080")
+		server.Name = *name
+		server.Discovery = network.DefaultDiscoveryAddr
+		if err := server.Start(); err != nil {
+			log.Fatal(err)
+		}
+		serverScene := game.NewServerScene(g, server)
+		serverScene.SetSnapshotRate(*snapshotRate)
+		if recording {
+			recorder := openRecorder(*record)
+			defer closeRecorder(recorder)
+			serverScene.Record(recorder)
+		}
+		scene = serverScene
+	} else if *hostMode {
+		server := network.NewServer(":8080")
+		server.Name = *name
+		server.Discovery = network.DefaultDiscoveryAddr
+		if err := server.Start(); err != nil {
+			log.Fatal(err)
+		}
+		hostScene, stop := game.HostAndPlay(context.Background(), g, server, *name, *team, *room)
+		hostScene.OnLeave = func() {
+			stop()
+			g.SetScene(scenes.NewMenuScene(g))
+		}
+		scene = hostScene
 	} else if *directMode {
-		scene = game.NewRabbitDirectScene(g)
+		directScene := game.NewRabbitDirectScene(g)
+		if recording {
+			recorder := openRecorder(*record)
+			defer closeRecorder(recorder)
+			directScene.Record(recorder)
+		}
+		scene = directScene
 	} else if *starsMode {
 		scene = scenes.NewStarsDirectScene(g)
 	} else if *clientMode {
 		fmt.Println("Iniciando en modo cliente...")
-		client, err := network.NewClient(url)
+		encoding, err := protocol.ParseEncoding(*encodingName)
+		if err != nil {
+			log.Fatal(err)
+		}
+		client, err := network.NewClient(*url, network.WithEncoding(encoding))
 		if err != nil {
 			log.Fatal("dial:", err)
+		} else if *spectate {
+			scene = game.NewSpectatorScene(g, client, *room)
 		} else {
-			scene = game.NewClientScene(g, client)
+			scene = game.NewClientScene(g, client, *name, *team, *room)
 		}
 		defer client.Close()
 	} else {
@@ -58,3 +120,18 @@ func main() {
 		panic(err)
 	}
 }
+
+// openRecorder crea el fichero de la grabación en path.
+func openRecorder(path string) *replay.Recorder {
+	recorder, err := replay.Create(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return recorder
+}
+
+func closeRecorder(recorder *replay.Recorder) {
+	if err := recorder.Close(); err != nil {
+		log.Println("record:", err)
+	}
+}